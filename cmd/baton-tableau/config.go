@@ -3,8 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
 
 	"github.com/conductorone/baton-sdk/pkg/cli"
+	"github.com/conductorone/baton-tableau/pkg/connector"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
 	"github.com/spf13/cobra"
 )
 
@@ -12,24 +18,198 @@ import (
 type config struct {
 	cli.BaseConfig `mapstructure:",squash"` // Puts the base config options in the same place as the connector options
 
-	AccessTokenName   string `mapstructure:"access-token-name"`
-	AccessTokenSecret string `mapstructure:"access-token-secret"`
-	ServerPath        string `mapstructure:"server-path"`
-	SiteID            string `mapstructure:"site-id"`
+	AccessTokenName         string   `mapstructure:"access-token-name"`
+	AccessTokenSecret       string   `mapstructure:"access-token-secret"`
+	ConnectedAppClientID    string   `mapstructure:"connected-app-client-id"`
+	ConnectedAppSecretID    string   `mapstructure:"connected-app-secret-id"`
+	ConnectedAppSecretValue string   `mapstructure:"connected-app-secret-value"`
+	ConnectedAppUsername    string   `mapstructure:"connected-app-username"`
+	ConnectedAppScopes      []string `mapstructure:"connected-app-scopes"`
+	EASKeyFile              string   `mapstructure:"eas-key-file"`
+	EASKeyID                string   `mapstructure:"eas-key-id"`
+	EASIssuer               string   `mapstructure:"eas-issuer"`
+	EASAudience             string   `mapstructure:"eas-audience"`
+	EASSubject              string   `mapstructure:"eas-subject"`
+	Username                string   `mapstructure:"username"`
+	Password                string   `mapstructure:"password"`
+	ImpersonateUserID       string   `mapstructure:"impersonate-user-id"`
+	CABundle                string   `mapstructure:"ca-bundle"`
+	ClientCert              string   `mapstructure:"client-cert"`
+	ClientKey               string   `mapstructure:"client-key"`
+	InsecureSkipVerify      bool     `mapstructure:"insecure-skip-verify"`
+	ProxyURL                string   `mapstructure:"proxy-url"`
+	ProxyUsername           string   `mapstructure:"proxy-username"`
+	ProxyPassword           string   `mapstructure:"proxy-password"`
+	NoProxy                 []string `mapstructure:"no-proxy"`
+	RequestsPerSecond       float64  `mapstructure:"requests-per-second"`
+	MaxRetries              int      `mapstructure:"max-retries"`
+	PageWorkers             int      `mapstructure:"page-workers"`
+	ServerPath              string   `mapstructure:"server-path"`
+	APIVersion              string   `mapstructure:"api-version"`
+	SiteID                  string   `mapstructure:"site-id"`
+	AllSites                bool     `mapstructure:"all-sites"`
+	InactiveDays            int      `mapstructure:"inactive-days"`
+	ServiceAccountPatterns  []string `mapstructure:"service-account-patterns"`
+	ServiceAuthSettings     []string `mapstructure:"service-account-auth-settings"`
+	ServiceAccounts         []string `mapstructure:"service-accounts"`
+	SystemAccounts          []string `mapstructure:"system-accounts"`
+	MembershipStrategy      string   `mapstructure:"membership-strategy"`
+	CaptureDir              string   `mapstructure:"capture-dir"`
+	CaptureHashEmails       bool     `mapstructure:"capture-hash-emails"`
+	ReplayDir               string   `mapstructure:"replay-dir"`
+}
+
+// usesConnectedApp reports whether the connector signs in with a connected app instead of a personal access token.
+func (cfg *config) usesConnectedApp() bool {
+	return cfg.ConnectedAppClientID != ""
+}
+
+// usesExternalAuthorizationServer reports whether the connector signs in with tokens signed by a local private key.
+func (cfg *config) usesExternalAuthorizationServer() bool {
+	return cfg.EASKeyFile != ""
+}
+
+// usesPassword reports whether the connector signs in with a username and password.
+func (cfg *config) usesPassword() bool {
+	return cfg.Username != ""
+}
+
+// serverURL returns the server path with a scheme, defaulting to https when none is given.
+func (cfg *config) serverURL() (string, error) {
+	serverPath := cfg.ServerPath
+	if !strings.Contains(serverPath, "://") {
+		serverPath = "https://" + serverPath
+	}
+
+	u, err := url.Parse(serverPath)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("unsupported scheme %q in server path", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("server path %q has no host", cfg.ServerPath)
+	}
+
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+func (cfg *config) httpOptions() tableau.HTTPOptions {
+	return tableau.HTTPOptions{
+		CABundlePath:       cfg.CABundle,
+		ClientCertPath:     cfg.ClientCert,
+		ClientKeyPath:      cfg.ClientKey,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		ProxyURL:           cfg.ProxyURL,
+		ProxyUsername:      cfg.ProxyUsername,
+		ProxyPassword:      cfg.ProxyPassword,
+		NoProxy:            cfg.NoProxy,
+		CaptureDir:         cfg.CaptureDir,
+		CaptureHashEmails:  cfg.CaptureHashEmails,
+		ReplayDir:          cfg.ReplayDir,
+	}
 }
 
 // validateConfig is run after the configuration is loaded, and should return an error if it isn't valid.
 // not checking if content-url is missing since it's optional on tableau server.
 func validateConfig(ctx context.Context, cfg *config) error {
-	if cfg.AccessTokenSecret == "" {
-		return fmt.Errorf("access token secret is missing")
+	modes := 0
+	for _, enabled := range []bool{cfg.usesConnectedApp(), cfg.usesExternalAuthorizationServer(), cfg.usesPassword()} {
+		if enabled {
+			modes++
+		}
 	}
-	if cfg.AccessTokenName == "" {
-		return fmt.Errorf("access token name is missing")
+
+	switch {
+	case modes > 1:
+		return fmt.Errorf("only one of connected app, external authorization server or username sign-in can be configured")
+	case cfg.ReplayDir != "":
+		// replayed sign-ins don't reach the server, so no credentials are needed.
+	case cfg.usesPassword():
+		if cfg.Password == "" {
+			return fmt.Errorf("password is missing")
+		}
+	case cfg.usesExternalAuthorizationServer():
+		if _, err := tableau.LoadPrivateKey(cfg.EASKeyFile); err != nil {
+			return fmt.Errorf("external authorization server key is invalid: %w", err)
+		}
+		if cfg.EASIssuer == "" {
+			return fmt.Errorf("external authorization server issuer is missing")
+		}
+		if cfg.EASSubject == "" {
+			return fmt.Errorf("external authorization server subject is missing")
+		}
+	case cfg.usesConnectedApp():
+		if cfg.ConnectedAppSecretID == "" {
+			return fmt.Errorf("connected app secret id is missing")
+		}
+		if cfg.ConnectedAppSecretValue == "" {
+			return fmt.Errorf("connected app secret value is missing")
+		}
+		if cfg.ConnectedAppUsername == "" {
+			return fmt.Errorf("connected app username is missing")
+		}
+	default:
+		if cfg.AccessTokenSecret == "" {
+			return fmt.Errorf("access token secret is missing")
+		}
+		if cfg.AccessTokenName == "" {
+			return fmt.Errorf("access token name is missing")
+		}
 	}
 	if cfg.ServerPath == "" {
 		return fmt.Errorf("server path is missing")
 	}
+	if _, err := cfg.serverURL(); err != nil {
+		return fmt.Errorf("server path is invalid: %w", err)
+	}
+	if _, err := tableau.NewTLSConfig(cfg.httpOptions()); err != nil {
+		return err
+	}
+	if cfg.ProxyURL != "" {
+		if _, err := tableau.NewProxyFunc(cfg.httpOptions()); err != nil {
+			return err
+		}
+	} else if cfg.ProxyUsername != "" {
+		return fmt.Errorf("proxy username is set without a proxy url")
+	}
+	if cfg.RequestsPerSecond < 0 {
+		return fmt.Errorf("requests per second must not be negative")
+	}
+	if cfg.MaxRetries < 0 {
+		return fmt.Errorf("max retries must not be negative")
+	}
+	if cfg.PageWorkers < 1 {
+		return fmt.Errorf("page workers must be at least 1")
+	}
+	if cfg.InactiveDays < 0 {
+		return fmt.Errorf("inactive days must not be negative")
+	}
+	for _, pattern := range cfg.ServiceAccountPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("service account pattern %q is invalid: %w", pattern, err)
+		}
+	}
+	if cfg.APIVersion != "" {
+		if _, err := tableau.ParseAPIVersion(cfg.APIVersion); err != nil {
+			return err
+		}
+	}
+	if _, err := connector.ParseMembershipStrategy(cfg.MembershipStrategy); err != nil {
+		return err
+	}
+	if cfg.CaptureDir != "" && cfg.ReplayDir != "" {
+		return fmt.Errorf("capture dir and replay dir can't be used together")
+	}
+	if cfg.CaptureHashEmails && cfg.CaptureDir == "" {
+		return fmt.Errorf("capture hash emails is set without a capture dir")
+	}
+	if cfg.ReplayDir != "" {
+		if info, err := os.Stat(cfg.ReplayDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("replay dir %q is not a directory", cfg.ReplayDir)
+		}
+	}
 
 	return nil
 }
@@ -38,6 +218,45 @@ func validateConfig(ctx context.Context, cfg *config) error {
 func cmdFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().String("access-token-name", "", "Name of the personal access token used to connect to the Tableau API. ($BATON_ACCESS_TOKEN_NAME)")
 	cmd.PersistentFlags().String("access-token-secret", "", "Secret of the personal access token used to connect to the Tableau API. ($BATON_ACCESS_TOKEN_SECRET)")
-	cmd.PersistentFlags().String("server-path", "", "Base url of your server or Tableau Cloud. ($BATON_SERVER_PATH)")
+	cmd.PersistentFlags().String("connected-app-client-id", "", "Client ID of the direct trust connected app. Used instead of a personal access token when set. ($BATON_CONNECTED_APP_CLIENT_ID)")
+	cmd.PersistentFlags().String("connected-app-secret-id", "", "Secret ID of the direct trust connected app. ($BATON_CONNECTED_APP_SECRET_ID)")
+	cmd.PersistentFlags().String("connected-app-secret-value", "", "Secret value of the direct trust connected app. ($BATON_CONNECTED_APP_SECRET_VALUE)")
+	cmd.PersistentFlags().String("connected-app-username", "", "Username of the Tableau user the connected app signs in as. ($BATON_CONNECTED_APP_USERNAME)")
+	cmd.PersistentFlags().StringSlice(
+		"connected-app-scopes",
+		tableau.DefaultConnectedAppScopes,
+		"Scopes requested in the connected app or external authorization server token. ($BATON_CONNECTED_APP_SCOPES)",
+	)
+	cmd.PersistentFlags().String("eas-key-file", "", "Path to the PEM encoded RSA or EC private key used to sign tokens for an external authorization server. ($BATON_EAS_KEY_FILE)")
+	cmd.PersistentFlags().String("eas-key-id", "", "Key ID sent in the kid header, matching a key in the JWKS registered with Tableau. ($BATON_EAS_KEY_ID)")
+	cmd.PersistentFlags().String("eas-issuer", "", "Issuer of the external authorization server registered with Tableau. ($BATON_EAS_ISSUER)")
+	cmd.PersistentFlags().String("eas-audience", tableau.DefaultConnectedAppAudience, "Audience claim of tokens signed for the external authorization server. ($BATON_EAS_AUDIENCE)")
+	cmd.PersistentFlags().String("eas-subject", "", "Subject claim of tokens signed for the external authorization server, usually the Tableau username. ($BATON_EAS_SUBJECT)")
+	cmd.PersistentFlags().String("username", "", "Name of the Tableau Server user to sign in as. Used instead of a personal access token when set. ($BATON_USERNAME)")
+	cmd.PersistentFlags().String("password", "", "Password of the Tableau Server user. ($BATON_PASSWORD)")
+	cmd.PersistentFlags().String("impersonate-user-id", "", "ID of the user a server administrator signs in as on behalf of. ($BATON_IMPERSONATE_USER_ID)")
+	cmd.PersistentFlags().String("server-path", "", "Base url of your server or Tableau Cloud. Defaults to https when no scheme is given. ($BATON_SERVER_PATH)")
+	cmd.PersistentFlags().String("ca-bundle", "", "Path to a PEM file of certificate authorities trusted in addition to the system ones. ($BATON_CA_BUNDLE)")
+	cmd.PersistentFlags().String("client-cert", "", "Path to a PEM encoded client certificate used for mutual TLS. ($BATON_CLIENT_CERT)")
+	cmd.PersistentFlags().String("client-key", "", "Path to the PEM encoded private key of the client certificate. ($BATON_CLIENT_KEY)")
+	cmd.PersistentFlags().Bool("insecure-skip-verify", false, "Skip verification of the server certificate. Only use this with lab servers. ($BATON_INSECURE_SKIP_VERIFY)")
+	cmd.PersistentFlags().String("proxy-url", "", "URL of the HTTP proxy used to reach Tableau. Defaults to the HTTPS_PROXY environment variable. ($BATON_PROXY_URL)")
+	cmd.PersistentFlags().String("proxy-username", "", "Username used to authenticate with the proxy. ($BATON_PROXY_USERNAME)")
+	cmd.PersistentFlags().String("proxy-password", "", "Password used to authenticate with the proxy. ($BATON_PROXY_PASSWORD)")
+	cmd.PersistentFlags().StringSlice("no-proxy", nil, "Hosts, domains and CIDRs that are reached without the proxy. ($BATON_NO_PROXY)")
+	cmd.PersistentFlags().Float64("requests-per-second", 0, "Maximum number of requests per second sent to Tableau. 0 means unlimited. ($BATON_REQUESTS_PER_SECOND)")
+	cmd.PersistentFlags().Int("max-retries", 5, "Number of times a throttled or unavailable request is retried. ($BATON_MAX_RETRIES)")
+	cmd.PersistentFlags().Int("page-workers", 4, "Number of pages of users and groups fetched concurrently. ($BATON_PAGE_WORKERS)")
+	cmd.PersistentFlags().String("api-version", "", "REST API version to use, for example 3.17. Defaults to the highest version supported by the server. ($BATON_API_VERSION)")
 	cmd.PersistentFlags().String("site-id", "", "On server it's referred as Site ID, on cloud it appears after /site/ in the Browser address bar. ($BATON_SITE_ID)")
+	cmd.PersistentFlags().Bool("all-sites", false, "Sync every site on the server, signing in to --site-id first. Requires a server administrator. ($BATON_ALL_SITES)")
+	cmd.PersistentFlags().Int("inactive-days", 0, "Mark users who haven't signed in for this many days, or never did, as disabled. 0 disables the check. ($BATON_INACTIVE_DAYS)")
+	cmd.PersistentFlags().StringSlice("service-account-patterns", nil, "Regular expressions matched against user names and emails of service accounts. ($BATON_SERVICE_ACCOUNT_PATTERNS)")
+	cmd.PersistentFlags().StringSlice("service-account-auth-settings", nil, "Auth settings, e.g. OpenID, only used by service accounts. ($BATON_SERVICE_ACCOUNT_AUTH_SETTINGS)")
+	cmd.PersistentFlags().StringSlice("service-accounts", nil, "IDs, names or emails of service accounts. ($BATON_SERVICE_ACCOUNTS)")
+	cmd.PersistentFlags().StringSlice("system-accounts", nil, "IDs, names or emails of system accounts. ($BATON_SYSTEM_ACCOUNTS)")
+	cmd.PersistentFlags().String("membership-strategy", "group", "How group memberships are read: group per group, user per user, auto the cheaper one per site. ($BATON_MEMBERSHIP_STRATEGY)")
+	cmd.PersistentFlags().String("capture-dir", "", "Record every request and response sent to Tableau to this directory, with tokens and secrets redacted. ($BATON_CAPTURE_DIR)")
+	cmd.PersistentFlags().Bool("capture-hash-emails", false, "Replace email addresses in the capture by a hash. ($BATON_CAPTURE_HASH_EMAILS)")
+	cmd.PersistentFlags().String("replay-dir", "", "Answer requests from a capture directory instead of Tableau. No credentials are needed and no requests are sent. ($BATON_REPLAY_DIR)")
 }
@@ -3,57 +3,158 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/conductorone/baton-sdk/pkg/cli"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
 	"github.com/conductorone/baton-sdk/pkg/types"
 	"github.com/conductorone/baton-tableau/pkg/connector"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"go.uber.org/zap"
 )
 
 var version = "dev"
 
+const signOutTimeout = 10 * time.Second
+
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-	cfg := &config{}
-	cmd, err := cli.NewCmd(ctx, "baton-tableau", cfg, validateConfig, getConnector)
+	err := run(ctx)
+	stop()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+}
 
-	cmd.Version = version
-	cmdFlags(cmd)
+// run executes the command and signs out of the Tableau session once it returns.
+func run(ctx context.Context) error {
+	var tb *connector.Tableau
+	l := ctxzap.Extract(ctx)
+	defer func() {
+		if tb == nil {
+			return
+		}
+		// ctx may already be cancelled, signing out still needs to reach the server.
+		closeCtx, cancel := context.WithTimeout(ctxzap.ToContext(context.Background(), l), signOutTimeout)
+		defer cancel()
+		if err := tb.Close(closeCtx); err != nil {
+			l.Warn("error signing out", zap.Error(err))
+		}
+	}()
 
-	err = cmd.Execute()
+	cfg := &config{}
+	cmd, err := cli.NewCmd(ctx, "baton-tableau", cfg, validateConfig, func(ctx context.Context, cfg *config) (types.ConnectorServer, error) {
+		c, cb, err := getConnector(ctx, cfg)
+		tb, l = cb, ctxzap.Extract(ctx)
+		return c, err
+	})
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+		return err
 	}
+
+	cmd.Version = version
+	cmdFlags(cmd)
+
+	return cmd.Execute()
 }
 
-func getConnector(ctx context.Context, cfg *config) (types.ConnectorServer, error) {
+func getConnector(ctx context.Context, cfg *config) (types.ConnectorServer, *connector.Tableau, error) {
 	l := ctxzap.Extract(ctx)
-	baseUrl, err := url.JoinPath("https://", cfg.ServerPath, "/api/3.17")
+	serverUrl, err := cfg.serverURL()
 	if err != nil {
-		l.Error("error creating base url", zap.Error(err))
+		l.Error("error creating server url", zap.Error(err))
+		return nil, nil, err
+	}
+
+	var apiVersion tableau.APIVersion
+	if cfg.APIVersion != "" {
+		apiVersion, err = tableau.ParseAPIVersion(cfg.APIVersion)
+		if err != nil {
+			l.Error("error parsing api version", zap.Error(err))
+			return nil, nil, err
+		}
 	}
 
-	cb, err := connector.New(ctx, baseUrl, cfg.SiteID, cfg.AccessTokenName, cfg.AccessTokenSecret)
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		l.Error("error creating authenticator", zap.Error(err))
+		return nil, nil, err
+	}
+
+	cb, err := connector.New(ctx, connector.Config{
+		ServerURL:         serverUrl,
+		ContentURL:        cfg.SiteID,
+		Auth:              auth,
+		APIVersion:        apiVersion,
+		HTTP:              cfg.httpOptions(),
+		RequestsPerSecond: cfg.RequestsPerSecond,
+		MaxRetries:        cfg.MaxRetries,
+		PageWorkers:       cfg.PageWorkers,
+		AllSites:          cfg.AllSites,
+		InactiveAfter:     time.Duration(cfg.InactiveDays) * 24 * time.Hour,
+		AccountTypes: connector.AccountTypeRules{
+			ServiceAccountPatterns:     cfg.ServiceAccountPatterns,
+			ServiceAccountAuthSettings: cfg.ServiceAuthSettings,
+			ServiceAccounts:            cfg.ServiceAccounts,
+			SystemAccounts:             cfg.SystemAccounts,
+		},
+		MembershipStrategy: connector.MembershipStrategy(cfg.MembershipStrategy),
+	})
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
-		return nil, err
+		return nil, nil, err
 	}
 
 	c, err := connectorbuilder.NewConnector(ctx, cb)
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
-		return nil, err
+		return nil, cb, err
 	}
 
-	return c, nil
+	return c, cb, nil
+}
+
+// newAuthenticator returns the sign-in method selected by the configuration.
+func newAuthenticator(cfg *config) (tableau.Authenticator, error) {
+	switch {
+	case cfg.usesExternalAuthorizationServer():
+		key, err := tableau.LoadPrivateKey(cfg.EASKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return &tableau.ExternalAuthorizationServer{
+			Key:      key,
+			KeyID:    cfg.EASKeyID,
+			Issuer:   cfg.EASIssuer,
+			Audience: cfg.EASAudience,
+			Subject:  cfg.EASSubject,
+			Scopes:   cfg.ConnectedAppScopes,
+		}, nil
+	case cfg.usesPassword():
+		return &tableau.UsernamePassword{
+			Username:          cfg.Username,
+			Password:          cfg.Password,
+			ImpersonateUserID: cfg.ImpersonateUserID,
+		}, nil
+	case cfg.usesConnectedApp():
+		return &tableau.ConnectedApp{
+			ClientID:    cfg.ConnectedAppClientID,
+			SecretID:    cfg.ConnectedAppSecretID,
+			SecretValue: cfg.ConnectedAppSecretValue,
+			Username:    cfg.ConnectedAppUsername,
+			Scopes:      cfg.ConnectedAppScopes,
+		}, nil
+	default:
+		return &tableau.PersonalAccessToken{
+			Name:   cfg.AccessTokenName,
+			Secret: cfg.AccessTokenSecret,
+		}, nil
+	}
 }
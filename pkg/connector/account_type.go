@@ -0,0 +1,93 @@
+package connector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+)
+
+// AccountTypeRules configure which users are synced as service or system accounts. Users matching no rule
+// are human accounts, except for the user the connector signs in as, which is always a service account.
+type AccountTypeRules struct {
+	// ServiceAccountPatterns are regular expressions matched against the name and email of users.
+	ServiceAccountPatterns []string
+	// ServiceAccountAuthSettings are auth settings, e.g. OpenID, only used by service accounts.
+	ServiceAccountAuthSettings []string
+	// ServiceAccounts and SystemAccounts list the ids, names or emails of accounts explicitly.
+	ServiceAccounts []string
+	SystemAccounts  []string
+}
+
+// accountTypes classifies users according to the AccountTypeRules.
+type accountTypes struct {
+	patterns     []*regexp.Regexp
+	authSettings map[string]bool
+	service      map[string]bool
+	system       map[string]bool
+}
+
+func newAccountTypes(rules AccountTypeRules) (*accountTypes, error) {
+	a := &accountTypes{
+		authSettings: lowerSet(rules.ServiceAccountAuthSettings),
+		service:      lowerSet(rules.ServiceAccounts),
+		system:       lowerSet(rules.SystemAccounts),
+	}
+
+	for _, pattern := range rules.ServiceAccountPatterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("tableau-connector: invalid service account pattern %q: %w", pattern, err)
+		}
+		a.patterns = append(a.patterns, re)
+	}
+
+	return a, nil
+}
+
+// accountType returns the account type of the user. Explicitly listed accounts take precedence over the
+// connector user, which takes precedence over auth settings and patterns.
+func (a *accountTypes) accountType(user *tableau.User, connectorUser bool) v2.UserTrait_AccountType {
+	if a == nil {
+		a = &accountTypes{}
+	}
+
+	identities := []string{strings.ToLower(user.ID), strings.ToLower(user.Name), strings.ToLower(user.Email)}
+	if a.matches(a.system, identities) {
+		return v2.UserTrait_ACCOUNT_TYPE_SYSTEM
+	}
+	if a.matches(a.service, identities) || connectorUser {
+		return v2.UserTrait_ACCOUNT_TYPE_SERVICE
+	}
+	if user.AuthSetting != "" && a.authSettings[strings.ToLower(user.AuthSetting)] {
+		return v2.UserTrait_ACCOUNT_TYPE_SERVICE
+	}
+	for _, re := range a.patterns {
+		if re.MatchString(user.Name) || (user.Email != "" && re.MatchString(user.Email)) {
+			return v2.UserTrait_ACCOUNT_TYPE_SERVICE
+		}
+	}
+
+	return v2.UserTrait_ACCOUNT_TYPE_HUMAN
+}
+
+func (a *accountTypes) matches(accounts map[string]bool, identities []string) bool {
+	for _, identity := range identities {
+		if identity != "" && accounts[identity] {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerSet(values []string) map[string]bool {
+	rv := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			rv[strings.ToLower(value)] = true
+		}
+	}
+	return rv
+}
@@ -0,0 +1,183 @@
+package connector
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+)
+
+// defaultMaxCachedPages is the number of pages kept by a syncCache, a few windows of concurrently fetched
+// pages.
+const defaultMaxCachedPages = 16
+
+// syncCache keeps the most recently read pages of Tableau list endpoints, so syncers reading the same pages
+// at about the same time share one request, e.g. the users of a site for the site role grants and the group
+// memberships. Older pages are dropped and requested again when they are read later, so a site is never
+// kept in memory as a whole: the Baton syncer lists every resource before the first grant, and the user
+// pages of the resource phase are mostly gone by the time the grants are listed. It also keeps values
+// derived from several requests, such as the group memberships of a site, for the duration of a sync.
+type syncCache struct {
+	mtx     sync.Mutex
+	entries map[string]*cacheEntry
+	// pages holds the keys of the cached pages, the most recently read first.
+	pages    *list.List
+	maxPages int
+}
+
+type cacheEntry struct {
+	ready chan struct{}
+	value interface{}
+	err   error
+	// page is the element of the entry in syncCache.pages, nil for values that aren't pages.
+	page *list.Element
+}
+
+func newSyncCache() *syncCache {
+	return &syncCache{
+		entries:  make(map[string]*cacheEntry),
+		pages:    list.New(),
+		maxPages: defaultMaxCachedPages,
+	}
+}
+
+// reset drops every entry. It is called when a new sync starts.
+func (c *syncCache) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries = make(map[string]*cacheEntry)
+	c.pages.Init()
+}
+
+// remove drops the entry cached under key. c.mtx must be held.
+func (c *syncCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	if entry.page != nil {
+		c.pages.Remove(entry.page)
+	}
+	delete(c.entries, key)
+}
+
+// invalidate drops the pages of an endpoint, and the value cached under the endpoint itself.
+func (c *syncCache) invalidate(endpoint string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	prefix := endpoint + "?"
+	for key := range c.entries {
+		if key == endpoint || strings.HasPrefix(key, prefix) {
+			c.remove(key)
+		}
+	}
+}
+
+// touchPage marks the page cached under key as the most recently read one, and drops the least recently
+// read pages beyond maxPages.
+func (c *syncCache) touchPage(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	if entry.page != nil {
+		c.pages.MoveToFront(entry.page)
+	} else {
+		entry.page = c.pages.PushFront(key)
+	}
+
+	for c.pages.Len() > c.maxPages {
+		c.remove(c.pages.Back().Value.(string))
+	}
+}
+
+// siteUsers returns the users of the site of the client, with its pages cached for the other readers of
+// the users: the user resources, the site role grants and the group memberships.
+func siteUsers(c *syncCache, client tableau.API) tableau.PageFunc[tableau.User] {
+	return cachedPages(c, sitesEndpoint(client.SiteID(), "users"), client.GetUsers)
+}
+
+// sitesEndpoint returns the cache key of a list endpoint of the site, e.g. "users".
+func sitesEndpoint(siteId string, path ...string) string {
+	return "sites/" + siteId + "/" + strings.Join(path, "/")
+}
+
+// page is a cached page of a list endpoint.
+type page[T any] struct {
+	items      []T
+	pagination tableau.Pagination
+}
+
+// cachedPages wraps fetch so a page of the endpoint read again while it is among the most recently read
+// pages of the cache isn't requested twice. Concurrent callers of the same page wait for the first request.
+// Failed requests are not cached.
+func cachedPages[T any](c *syncCache, endpoint string, fetch tableau.PageFunc[T]) tableau.PageFunc[T] {
+	return func(ctx context.Context, pageSize int, pageNumber int) ([]T, tableau.Pagination, error) {
+		key := fmt.Sprintf("%s?pageSize=%d&pageNumber=%d", endpoint, pageSize, pageNumber)
+
+		p, err := cachedValue(ctx, c, key, func(ctx context.Context) (page[T], error) {
+			items, pagination, err := fetch(ctx, pageSize, pageNumber)
+			return page[T]{items: items, pagination: pagination}, err
+		})
+		if err != nil {
+			return nil, tableau.Pagination{}, err
+		}
+
+		c.touchPage(key)
+		return p.items, p.pagination, nil
+	}
+}
+
+// cachedValue returns the value cached under key, computing it with fn on the first call of the sync.
+// Concurrent callers wait for the first one. Errors are not cached, callers after a failure call fn again.
+func cachedValue[T any](ctx context.Context, c *syncCache, key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	c.mtx.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cacheEntry{ready: make(chan struct{})}
+		c.entries[key] = entry
+	}
+	c.mtx.Unlock()
+
+	if ok {
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+
+		if entry.err == nil {
+			value, _ := entry.value.(T)
+			return value, nil
+		}
+
+		// the first call failed, so try again without caching.
+		return fn(ctx)
+	}
+
+	value, err := fn(ctx)
+	entry.value = value
+	entry.err = err
+
+	if err != nil {
+		c.mtx.Lock()
+		if c.entries[key] == entry {
+			c.remove(key)
+		}
+		c.mtx.Unlock()
+	}
+	close(entry.ready)
+
+	return value, err
+}
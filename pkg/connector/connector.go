@@ -3,13 +3,14 @@ package connector
 import (
 	"context"
 	"fmt"
+	"time"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
-	"github.com/conductorone/baton-sdk/pkg/uhttp"
 	"github.com/conductorone/baton-tableau/pkg/tableau"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
 )
 
 var (
@@ -35,33 +36,112 @@ var (
 )
 
 type Tableau struct {
-	client                    *tableau.Client
-	personalAccessTokenName   string
-	personalAccessTokenSecret string
-	contentUrl                string
-	baseUrl                   string
+	client     *tableau.Client
+	sites      *siteRegistry
+	session    *tableau.Session
+	cache      *syncCache
+	users      userOptions
+	membership MembershipStrategy
+	contentUrl string
+	baseUrl    string
 }
 
-func New(ctx context.Context, baseUrl string, contentUrl string, personalAccessTokenName string, personalAccessTokenSecret string) (*Tableau, error) {
-	httpClient, err := uhttp.NewClient(ctx, uhttp.WithLogger(true, ctxzap.Extract(ctx)))
+// Config holds the options used to create the connector.
+type Config struct {
+	// ServerURL is the scheme and host of the Tableau Server or Tableau Cloud pod.
+	ServerURL string
+	// ContentURL identifies the site, it is empty for the default site.
+	ContentURL string
+	Auth       tableau.Authenticator
+	// APIVersion pins the REST API version. The zero value uses the highest version supported by the server.
+	APIVersion tableau.APIVersion
+	HTTP       tableau.HTTPOptions
+	// RequestsPerSecond caps the request rate sent to Tableau. Zero means unlimited.
+	RequestsPerSecond float64
+	// PageWorkers is how many pages of a list endpoint are fetched concurrently.
+	PageWorkers int
+	// MaxRetries is how often throttled or unavailable requests are retried. Zero disables retries.
+	MaxRetries int
+	// AllSites syncs every site on the server instead of only ContentURL. It requires a server administrator.
+	AllSites bool
+	// InactiveAfter disables users who haven't signed in for longer, or never did. Zero disables the check.
+	InactiveAfter time.Duration
+	// AccountTypes configures which users are synced as service or system accounts.
+	AccountTypes AccountTypeRules
+	// MembershipStrategy selects how group memberships are read. The zero value reads them per group.
+	MembershipStrategy MembershipStrategy
+}
+
+// New signs in to the site and returns the connector.
+func New(ctx context.Context, cfg Config) (*Tableau, error) {
+	l := ctxzap.Extract(ctx)
+	accountTypes, err := newAccountTypes(cfg.AccountTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	membership, err := ParseMembershipStrategy(string(cfg.MembershipStrategy))
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := tableau.NewHTTPClient(ctx, cfg.HTTP)
 	if err != nil {
 		return nil, err
 	}
 
-	credentials, err := tableau.Login(ctx, baseUrl, contentUrl, personalAccessTokenSecret, personalAccessTokenName)
+	apiVersion := cfg.APIVersion
+	if apiVersion == (tableau.APIVersion{}) {
+		apiVersion, err = tableau.NegotiateAPIVersion(ctx, cfg.ServerURL, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("tableau-connector: failed to negotiate api version: %w", err)
+		}
+		l.Debug("tableau-connector: negotiated api version", zap.Stringer("api_version", apiVersion))
+	}
+
+	baseUrl, err := tableau.APIBaseURL(cfg.ServerURL, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	supported, err := supportedMembershipStrategy(membership, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	if supported != membership {
+		l.Debug("tableau-connector: api version doesn't list the groups of users, reading memberships per group",
+			zap.Stringer("api_version", apiVersion))
+		membership = supported
+	}
+
+	session, err := tableau.NewSession(ctx, baseUrl, cfg.ContentURL, cfg.Auth, httpClient)
 	if err != nil {
 		return nil, fmt.Errorf("tableau-connector: failed to login: %w", err)
 	}
 
+	client := tableau.NewClient(session, baseUrl, apiVersion, httpClient,
+		tableau.WithRequestsPerSecond(cfg.RequestsPerSecond),
+		tableau.WithMaxRetries(cfg.MaxRetries),
+		tableau.WithPageWorkers(cfg.PageWorkers),
+	)
+
 	return &Tableau{
-		client:                    tableau.NewClient(credentials.Token, credentials.Site.ID, baseUrl, credentials.User.ID, httpClient),
-		personalAccessTokenName:   personalAccessTokenName,
-		personalAccessTokenSecret: personalAccessTokenSecret,
-		contentUrl:                contentUrl,
-		baseUrl:                   baseUrl,
+		client:     client,
+		sites:      newSiteRegistry(cfg.AllSites),
+		session:    session,
+		cache:      newSyncCache(),
+		users:      userOptions{inactiveAfter: cfg.InactiveAfter, accountTypes: accountTypes},
+		membership: membership,
+		contentUrl: cfg.ContentURL,
+		baseUrl:    baseUrl,
 	}, nil
 }
 
+// Close signs out of the Tableau session.
+func (tb *Tableau) Close(ctx context.Context) error {
+	return tb.session.Close(ctx)
+}
+
 func (tb *Tableau) Metadata(ctx context.Context) (*v2.ConnectorMetadata, error) {
 	return &v2.ConnectorMetadata{
 		DisplayName: "Tableau",
@@ -75,13 +155,26 @@ func (tb *Tableau) Validate(ctx context.Context) (annotations.Annotations, error
 		return nil, fmt.Errorf("tableau-connector: failed to authorize current user: %w", err)
 	}
 
+	if tb.sites.allSites {
+		if _, _, err := tb.client.GetSites(ctx, 1, 1); err != nil {
+			return nil, fmt.Errorf("tableau-connector: failed to list sites, syncing all sites requires a server administrator: %w", err)
+		}
+	}
+
+	ctxzap.Extract(ctx).Debug(
+		"tableau-connector: validated session",
+		zap.String("site_id", tb.session.Site().ID),
+		zap.String("site_content_url", tb.session.Site().ContentURL),
+		zap.String("user_id", tb.session.User().ID),
+	)
+
 	return nil, nil
 }
 
 func (tb *Tableau) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
 	return []connectorbuilder.ResourceSyncer{
-		userBuilder(tb.client),
-		siteBuilder(tb.client),
-		groupBuilder(tb.client),
+		userBuilder(tb.client, tb.sites, tb.cache, tb.users),
+		siteBuilder(tb.client, tb.sites, tb.cache),
+		groupBuilder(tb.client, tb.sites, tb.cache, tb.membership),
 	}
 }
@@ -0,0 +1,715 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+	"github.com/conductorone/baton-tableau/pkg/tableau/tableautest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T, opts ...tableautest.Option) *tableautest.Server {
+	t.Helper()
+
+	fixture, err := tableautest.LoadFixture("testdata/fixture.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// small pages, so syncers have to page through every list.
+	srv := tableautest.NewServer(fixture, append([]tableautest.Option{tableautest.WithMaxPageSize(2)}, opts...)...)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newTestConnector(t *testing.T, srv *tableautest.Server, allSites bool) *Tableau {
+	t.Helper()
+
+	return newTestConnectorWithConfig(t, srv, Config{AllSites: allSites})
+}
+
+// newTestConnectorWithConfig returns a connector signed in to srv, configured by cfg.
+func newTestConnectorWithConfig(t *testing.T, srv *tableautest.Server, cfg Config) *Tableau {
+	t.Helper()
+
+	cfg.ServerURL = srv.URL
+	cfg.Auth = &tableau.PersonalAccessToken{Name: "baton", Secret: "secret"}
+	cfg.PageWorkers = 2
+
+	tb, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("creating connector: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = tb.Close(context.Background())
+	})
+
+	return tb
+}
+
+// listAll calls a List, Entitlements or Grants method until there are no pages left.
+func listAll[T any](t *testing.T, list func(token *pagination.Token) ([]T, string, annotations.Annotations, error)) []T {
+	t.Helper()
+
+	var rv []T
+	token := &pagination.Token{}
+	for {
+		items, next, _, err := list(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rv = append(rv, items...)
+		if next == "" {
+			return rv
+		}
+		token = &pagination.Token{Token: next}
+	}
+}
+
+func listResources(t *testing.T, syncer interface {
+	List(context.Context, *v2.ResourceId, *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error)
+}, parentId *v2.ResourceId) []*v2.Resource {
+	t.Helper()
+
+	return listAll(t, func(token *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+		return syncer.List(context.Background(), parentId, token)
+	})
+}
+
+func resourceIds(resources []*v2.Resource) []string {
+	var rv []string
+	for _, r := range resources {
+		rv = append(rv, r.Id.Resource)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func grantPrincipals(grants []*v2.Grant) []string {
+	var rv []string
+	for _, g := range grants {
+		// entitlement ids end with the entitlement name.
+		id := g.Entitlement.Id
+		rv = append(rv, id[strings.LastIndex(id, ":")+1:]+":"+g.Principal.Id.Resource)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestValidate(t *testing.T) {
+	srv := newTestServer(t)
+	tb := newTestConnector(t, srv, true)
+
+	if _, err := tb.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListResources(t *testing.T) {
+	srv := newTestServer(t)
+	tb := newTestConnector(t, srv, false)
+
+	sites := listResources(t, siteBuilder(tb.client, tb.sites, tb.cache), nil)
+	if got := resourceIds(sites); !equal(got, []string{"site-default"}) {
+		t.Fatalf("unexpected sites %v", got)
+	}
+
+	users := listResources(t, userBuilder(tb.client, tb.sites, tb.cache, tb.users), sites[0].Id)
+	want := []string{"user-ada", "user-admin", "user-alan", "user-grace", "user-linus"}
+	if got := resourceIds(users); !equal(got, want) {
+		t.Errorf("unexpected users %v", got)
+	}
+	for _, user := range users {
+		if user.ParentResourceId.Resource != "site-default" {
+			t.Errorf("user %s has parent %v", user.Id.Resource, user.ParentResourceId)
+		}
+	}
+
+	groups := listResources(t, groupBuilder(tb.client, tb.sites, tb.cache, tb.membership), sites[0].Id)
+	if got := resourceIds(groups); !equal(got, []string{"group-all", "group-analysts"}) {
+		t.Errorf("unexpected groups %v", got)
+	}
+
+	if got := listResources(t, userBuilder(tb.client, tb.sites, tb.cache, tb.users), nil); len(got) != 0 {
+		t.Errorf("expected no users without a parent site, got %v", resourceIds(got))
+	}
+}
+
+func TestSiteGrantsReuseUserPages(t *testing.T) {
+	srv := newTestServer(t)
+	tb := newTestConnector(t, srv, false)
+	ctx := context.Background()
+
+	sb := siteBuilder(tb.client, tb.sites, tb.cache)
+	sites := listResources(t, sb, nil)
+	listResources(t, userBuilder(tb.client, tb.sites, tb.cache, tb.users), sites[0].Id)
+
+	pages := srv.CountRequests(http.MethodGet, "sites/site-default/users")
+	if pages != 3 {
+		t.Fatalf("expected 3 pages of users, got %d", pages)
+	}
+
+	grants := listAll(t, func(token *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+		return sb.Grants(ctx, sites[0], token)
+	})
+	want := []string{
+		"creator:user-ada",
+		"explorer:user-alan",
+		"site administrator creator:user-admin",
+		"unlicensed:user-linus",
+		"viewer:user-grace",
+	}
+	if got := grantPrincipals(grants); !equal(got, want) {
+		t.Errorf("unexpected site grants %v", got)
+	}
+
+	if got := srv.CountRequests(http.MethodGet, "sites/site-default/users"); got != pages {
+		t.Errorf("expected site grants to reuse the user pages, got %d requests", got)
+	}
+
+	// the pages stay cached, they are fewer than the pages kept by the cache.
+	tb.cache.mtx.Lock()
+	cached := tb.cache.pages.Len()
+	tb.cache.mtx.Unlock()
+	if cached != pages {
+		t.Errorf("expected the %d user pages to be cached, got %d", pages, cached)
+	}
+
+	// listing sites again starts a new sync, which reads the users again.
+	listResources(t, sb, nil)
+	listResources(t, userBuilder(tb.client, tb.sites, tb.cache, tb.users), sites[0].Id)
+	if got := srv.CountRequests(http.MethodGet, "sites/site-default/users"); got != 2*pages {
+		t.Errorf("expected a new sync to read the users again, got %d requests", got)
+	}
+}
+
+func TestGroupGrantAndRevoke(t *testing.T) {
+	srv := newTestServer(t)
+	tb := newTestConnector(t, srv, false)
+	ctx := context.Background()
+
+	sites := listResources(t, siteBuilder(tb.client, tb.sites, tb.cache), nil)
+	users := listResources(t, userBuilder(tb.client, tb.sites, tb.cache, tb.users), sites[0].Id)
+	gb := groupBuilder(tb.client, tb.sites, tb.cache, tb.membership)
+
+	var analysts *v2.Resource
+	for _, group := range listResources(t, gb, sites[0].Id) {
+		if group.Id.Resource == "group-analysts" {
+			analysts = group
+		}
+	}
+	if analysts == nil {
+		t.Fatal("group-analysts not found")
+	}
+
+	groupGrants := func() []string {
+		return grantPrincipals(listAll(t, func(token *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+			return gb.Grants(ctx, analysts, token)
+		}))
+	}
+
+	if got := groupGrants(); !equal(got, []string{"member:user-ada", "member:user-alan"}) {
+		t.Fatalf("unexpected group grants %v", got)
+	}
+
+	entitlements, _, _, err := gb.Entitlements(ctx, analysts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var grace *v2.Resource
+	for _, user := range users {
+		if user.Id.Resource == "user-grace" {
+			grace = user
+		}
+	}
+
+	if _, err := gb.Grant(ctx, grace, entitlements[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := groupGrants(); !equal(got, []string{"member:user-ada", "member:user-alan", "member:user-grace"}) {
+		t.Errorf("expected grace to be a member after the grant, got %v", got)
+	}
+
+	revoke := &v2.Grant{Entitlement: entitlements[0], Principal: grace}
+	if _, err := gb.Revoke(ctx, revoke); err != nil {
+		t.Fatal(err)
+	}
+	if got := groupGrants(); !equal(got, []string{"member:user-ada", "member:user-alan"}) {
+		t.Errorf("expected grace to be removed after the revoke, got %v", got)
+	}
+
+	if _, err := gb.Grant(ctx, analysts, entitlements[0]); err == nil {
+		t.Error("expected granting membership to a group to fail")
+	}
+}
+
+func TestAllSites(t *testing.T) {
+	srv := newTestServer(t)
+	tb := newTestConnector(t, srv, true)
+
+	sites := listResources(t, siteBuilder(tb.client, tb.sites, tb.cache), nil)
+	if got := resourceIds(sites); !equal(got, []string{"site-default", "site-marketing"}) {
+		t.Fatalf("expected the suspended site to be skipped, got %v", got)
+	}
+
+	wantUsers := map[string][]string{
+		"site-default":   {"user-ada", "user-admin", "user-alan", "user-grace", "user-linus"},
+		"site-marketing": {"marketing-admin", "marketing-don", "marketing-joan", "marketing-peggy"},
+	}
+	for _, site := range sites {
+		users := listResources(t, userBuilder(tb.client, tb.sites, tb.cache, tb.users), site.Id)
+		if got := resourceIds(users); !equal(got, wantUsers[site.Id.Resource]) {
+			t.Errorf("unexpected users of %s: %v", site.Id.Resource, got)
+		}
+	}
+
+	// a resumed sync may ask for a site before listing them.
+	resumed := newTestConnector(t, srv, true)
+	users := listResources(t, userBuilder(resumed.client, resumed.sites, resumed.cache, resumed.users), sites[1].Id)
+	if got := resourceIds(users); !equal(got, wantUsers["site-marketing"]) {
+		t.Errorf("unexpected users of the resumed site: %v", got)
+	}
+}
+
+func newTestMemory(t *testing.T) *tableautest.Memory {
+	t.Helper()
+
+	fixture, err := tableautest.LoadFixture("testdata/fixture.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tableautest.NewMemory(fixture, tableautest.WithMaxPageSize(2), tableautest.WithPageWorkers(2))
+}
+
+func TestListReturnsAPIErrors(t *testing.T) {
+	memory := newTestMemory(t)
+	ub := userBuilder(memory, newSiteRegistry(false), newSyncCache(), userOptions{})
+	ctx := context.Background()
+	site := &v2.ResourceId{ResourceType: resourceTypeSite.Id, Resource: memory.SiteID()}
+
+	memory.Fail("GetUsers", &tableau.APIError{StatusCode: http.StatusServiceUnavailable})
+	memory.SetRateLimit(&v2.RateLimitDescription{Status: v2.RateLimitDescription_STATUS_OVERLIMIT})
+
+	_, _, annos, err := ub.List(ctx, site, &pagination.Token{})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected code Unavailable, got %v", err)
+	}
+
+	rateLimit := &v2.RateLimitDescription{}
+	if ok, err := annos.Pick(rateLimit); err != nil || !ok {
+		t.Fatalf("expected a rate limit annotation, got %v", err)
+	}
+	if rateLimit.Status != v2.RateLimitDescription_STATUS_OVERLIMIT {
+		t.Errorf("expected rate limit status OVERLIMIT, got %v", rateLimit.Status)
+	}
+
+	// the failed page isn't cached, so the syncer's retry reads it again.
+	users, _, _, err := ub.List(ctx, site, &pagination.Token{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 4 {
+		t.Errorf("expected 2 pages of 2 users, got %d users", len(users))
+	}
+}
+
+func TestListResumesClampedPages(t *testing.T) {
+	site := tableautest.Site{Site: tableau.Site{ID: "site-default", Name: "Default"}}
+	for i := 0; i < 300; i++ {
+		site.Users = append(site.Users, tableau.User{ID: fmt.Sprintf("user-%03d", i), Name: fmt.Sprintf("user%03d", i)})
+	}
+
+	for _, workers := range []int{1, 2} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			// pages are clamped to 30 users, so resumed page tokens don't point at multiples of 100 users.
+			memory := tableautest.NewMemory(tableautest.Fixture{Sites: []tableautest.Site{site}},
+				tableautest.WithMaxPageSize(30), tableautest.WithPageWorkers(workers))
+			ub := userBuilder(memory, newSiteRegistry(false), newSyncCache(), userOptions{})
+
+			users := listResources(t, ub, &v2.ResourceId{ResourceType: resourceTypeSite.Id, Resource: memory.SiteID()})
+			if len(users) != 300 {
+				t.Fatalf("expected 300 users, got %d", len(users))
+			}
+			if got := memory.Calls("GetUsers"); got != 10 {
+				t.Errorf("expected 10 page requests, got %d", got)
+			}
+		})
+	}
+}
+
+func TestGrantReturnsAPIErrors(t *testing.T) {
+	memory := newTestMemory(t)
+	gb := groupBuilder(memory, newSiteRegistry(false), newSyncCache(), MembershipByGroup)
+	ctx := context.Background()
+
+	group, err := groupResource(&tableau.Group{ID: "group-analysts", Name: "Analysts"},
+		&v2.ResourceId{ResourceType: resourceTypeSite.Id, Resource: memory.SiteID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entitlements, _, _, err := gb.Entitlements(ctx, group, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := userResource(ctx, &tableau.User{ID: "user-ada"}, group.ParentResourceId, userOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gb.Grant(ctx, user, entitlements[0]); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected code AlreadyExists for an existing member, got %v", err)
+	}
+
+	memory.Fail("RemoveUserFromGroup", &tableau.APIError{StatusCode: http.StatusForbidden})
+	revoke := &v2.Grant{Entitlement: entitlements[0], Principal: user}
+	if _, err := gb.Revoke(ctx, revoke); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected code PermissionDenied, got %v", err)
+	}
+	if got := memory.Members(memory.SiteID(), "group-analysts"); !equal(got, []string{"user-ada", "user-alan"}) {
+		t.Errorf("expected membership to be unchanged, got %v", got)
+	}
+}
+
+func TestUserStatus(t *testing.T) {
+	now := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	inactive := userOptions{inactiveAfter: 90 * 24 * time.Hour, now: func() time.Time { return now }}
+
+	tests := []struct {
+		name       string
+		user       tableau.User
+		opts       userOptions
+		wantStatus v2.UserTrait_Status_Status
+		wantReason string
+	}{
+		{
+			name:       "licensed",
+			user:       tableau.User{SiteRole: "Creator"},
+			wantStatus: v2.UserTrait_Status_STATUS_ENABLED,
+			wantReason: statusReasonActive,
+		},
+		{
+			name:       "unlicensed",
+			user:       tableau.User{SiteRole: "Unlicensed", LastLogin: "2024-05-09T00:00:00Z"},
+			opts:       inactive,
+			wantStatus: v2.UserTrait_Status_STATUS_DISABLED,
+			wantReason: statusReasonUnlicensed,
+		},
+		{
+			name:       "never signed in without threshold",
+			user:       tableau.User{SiteRole: "Viewer"},
+			wantStatus: v2.UserTrait_Status_STATUS_ENABLED,
+			wantReason: statusReasonActive,
+		},
+		{
+			name:       "never signed in",
+			user:       tableau.User{SiteRole: "Viewer"},
+			opts:       inactive,
+			wantStatus: v2.UserTrait_Status_STATUS_DISABLED,
+			wantReason: statusReasonNeverSignedIn,
+		},
+		{
+			name:       "signed in recently",
+			user:       tableau.User{SiteRole: "Viewer", LastLogin: "2024-04-01T12:00:00Z"},
+			opts:       inactive,
+			wantStatus: v2.UserTrait_Status_STATUS_ENABLED,
+			wantReason: statusReasonActive,
+		},
+		{
+			name:       "inactive",
+			user:       tableau.User{SiteRole: "Viewer", LastLogin: "2024-01-01T12:00:00Z"},
+			opts:       inactive,
+			wantStatus: v2.UserTrait_Status_STATUS_DISABLED,
+			wantReason: statusReasonInactive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reason := userStatus(context.Background(), &tt.user, tt.opts)
+			if status != tt.wantStatus || reason != tt.wantReason {
+				t.Errorf("expected %v (%s), got %v (%s)", tt.wantStatus, tt.wantReason, status, reason)
+			}
+		})
+	}
+}
+
+func TestAccountTypes(t *testing.T) {
+	accountTypes, err := newAccountTypes(AccountTypeRules{
+		ServiceAccountPatterns:     []string{`^svc[-_]`, `@integrations\.example\.com$`},
+		ServiceAccountAuthSettings: []string{"OpenID"},
+		ServiceAccounts:            []string{"user-embed"},
+		SystemAccounts:             []string{"Guest"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		user          tableau.User
+		connectorUser bool
+		want          v2.UserTrait_AccountType
+	}{
+		{name: "human", user: tableau.User{ID: "user-ada", Name: "ada", Email: "ada@example.com"}, want: v2.UserTrait_ACCOUNT_TYPE_HUMAN},
+		{name: "name pattern", user: tableau.User{Name: "SVC_extracts"}, want: v2.UserTrait_ACCOUNT_TYPE_SERVICE},
+		{name: "email pattern", user: tableau.User{Name: "bot", Email: "bot@integrations.example.com"}, want: v2.UserTrait_ACCOUNT_TYPE_SERVICE},
+		{name: "auth setting", user: tableau.User{Name: "runner", AuthSetting: "OpenID"}, want: v2.UserTrait_ACCOUNT_TYPE_SERVICE},
+		{name: "listed service account", user: tableau.User{ID: "user-embed", Name: "embed"}, want: v2.UserTrait_ACCOUNT_TYPE_SERVICE},
+		{name: "connector user", user: tableau.User{ID: "user-admin", Name: "admin"}, connectorUser: true, want: v2.UserTrait_ACCOUNT_TYPE_SERVICE},
+		{name: "listed system account", user: tableau.User{ID: "user-guest", Name: "guest"}, connectorUser: true, want: v2.UserTrait_ACCOUNT_TYPE_SYSTEM},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := accountTypes.accountType(&tt.user, tt.connectorUser); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := newAccountTypes(AccountTypeRules{ServiceAccountPatterns: []string{"("}}); err == nil {
+		t.Errorf("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestNormalizeIdentity(t *testing.T) {
+	tests := []struct {
+		name string
+		user tableau.User
+		want userIdentity
+	}{
+		{
+			name: "local user",
+			user: tableau.User{ID: "user-ada", Name: "ada", FullName: "Ada Lovelace", Email: "ada@example.com", Domain: tableau.Domain{Name: "local"}},
+			want: userIdentity{displayName: "Ada Lovelace", firstName: "Ada", lastName: "Lovelace", login: "ada", email: "ada@example.com"},
+		},
+		{
+			name: "active directory user without full name",
+			user: tableau.User{ID: "user-peggy", Name: "peggy", Domain: tableau.Domain{Name: "CORP"}},
+			want: userIdentity{displayName: `CORP\peggy`, login: `CORP\peggy`},
+		},
+		{
+			name: "cloud user named by email",
+			user: tableau.User{ID: "user-joan", Name: "joan@example.com", FullName: " Joan  Holloway ", Domain: tableau.Domain{Name: "external"}},
+			want: userIdentity{displayName: "Joan Holloway", firstName: "Joan", lastName: "Holloway", login: "joan@example.com", email: "joan@example.com"},
+		},
+		{
+			name: "only an id",
+			user: tableau.User{ID: "user-unknown"},
+			want: userIdentity{displayName: "user-unknown"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeIdentity(&tt.user); got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMembershipStrategies(t *testing.T) {
+	// more groups than users, so auto reads memberships from the users.
+	manyGroups := tableautest.Fixture{Sites: []tableautest.Site{{
+		Site:  tableau.Site{ID: "site-default", Name: "Default"},
+		Users: []tableau.User{{ID: "user-ada", Name: "ada"}, {ID: "user-alan", Name: "alan"}},
+		Groups: []tableautest.Group{
+			{Group: tableau.Group{ID: "group-a", Name: "A"}, Members: []string{"user-ada"}},
+			{Group: tableau.Group{ID: "group-b", Name: "B"}, Members: []string{"user-ada", "user-alan"}},
+			{Group: tableau.Group{ID: "group-c", Name: "C"}},
+		},
+	}}}
+	fixture, err := tableautest.LoadFixture("testdata/fixture.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		fixture           tableautest.Fixture
+		strategy          MembershipStrategy
+		wantGroupRequests bool
+	}{
+		{name: "group", fixture: manyGroups, strategy: MembershipByGroup, wantGroupRequests: true},
+		{name: "user", fixture: manyGroups, strategy: MembershipByUser},
+		{name: "auto with many groups", fixture: manyGroups, strategy: MembershipAuto},
+		{name: "auto with many users", fixture: fixture, strategy: MembershipAuto, wantGroupRequests: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			memory := tableautest.NewMemory(tt.fixture, tableautest.WithMaxPageSize(2), tableautest.WithPageWorkers(2))
+			gb := groupBuilder(memory, newSiteRegistry(false), newSyncCache(), tt.strategy)
+			site := &v2.ResourceId{ResourceType: resourceTypeSite.Id, Resource: memory.SiteID()}
+
+			grants := func(group *v2.Resource) []string {
+				return grantPrincipals(listAll(t, func(token *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+					return gb.Grants(ctx, group, token)
+				}))
+			}
+
+			groups := listResources(t, gb, site)
+			for _, group := range groups {
+				want := make([]string, 0)
+				for _, member := range memory.Members(memory.SiteID(), group.Id.Resource) {
+					want = append(want, "member:"+member)
+				}
+				sort.Strings(want)
+				if got := grants(group); !equal(got, want) {
+					t.Errorf("unexpected grants of %s: %v, expected %v", group.Id.Resource, got, want)
+				}
+			}
+
+			if got := memory.Calls("GetGroupUsers") > 0; got != tt.wantGroupRequests {
+				t.Errorf("expected group member requests %v, got %d requests", tt.wantGroupRequests, memory.Calls("GetGroupUsers"))
+			}
+			if got := memory.Calls("GetUserGroups") > 0; got == tt.wantGroupRequests {
+				t.Errorf("expected user group requests %v, got %d requests", !tt.wantGroupRequests, memory.Calls("GetUserGroups"))
+			}
+
+			// grants are read again after a membership change.
+			user, err := userResource(ctx, &tableau.User{ID: tt.fixture.Sites[0].Users[0].ID}, site, userOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			group := groups[len(groups)-1]
+			entitlements, _, _, err := gb.Entitlements(ctx, group, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := len(grants(group))
+			want := before + 1
+			if contains(memory.Members(memory.SiteID(), group.Id.Resource), user.Id.Resource) {
+				_, err = gb.Revoke(ctx, &v2.Grant{Entitlement: entitlements[0], Principal: user})
+				want = before - 1
+			} else {
+				_, err = gb.Grant(ctx, user, entitlements[0])
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(grants(group)); got != want {
+				t.Errorf("expected %d grants of %s after the membership changed, got %d", want, group.Id.Resource, got)
+			}
+		})
+	}
+}
+
+func TestMembershipsShareUserPages(t *testing.T) {
+	// more groups than users, so auto reads memberships from the users.
+	fixture := tableautest.Fixture{Sites: []tableautest.Site{{
+		Site:  tableau.Site{ID: "site-default", Name: "Default"},
+		Users: []tableau.User{{ID: "user-ada", Name: "ada"}, {ID: "user-alan", Name: "alan"}, {ID: "user-grace", Name: "grace"}},
+		Groups: []tableautest.Group{
+			{Group: tableau.Group{ID: "group-a", Name: "A"}, Members: []string{"user-ada"}},
+			{Group: tableau.Group{ID: "group-b", Name: "B"}, Members: []string{"user-alan", "user-grace"}},
+			{Group: tableau.Group{ID: "group-c", Name: "C"}},
+			{Group: tableau.Group{ID: "group-d", Name: "D"}},
+		},
+	}}}
+	memory := tableautest.NewMemory(fixture, tableautest.WithMaxPageSize(2), tableautest.WithPageWorkers(2))
+	cache := newSyncCache()
+	ctx := context.Background()
+	site := &v2.ResourceId{ResourceType: resourceTypeSite.Id, Resource: memory.SiteID()}
+
+	listResources(t, userBuilder(memory, newSiteRegistry(false), cache, userOptions{}), site)
+	pages := memory.Calls("GetUsers")
+	if pages != 2 {
+		t.Fatalf("expected 2 pages of users, got %d", pages)
+	}
+
+	gb := groupBuilder(memory, newSiteRegistry(false), cache, MembershipAuto)
+	groups := listResources(t, gb, site)
+	grants := listAll(t, func(token *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+		return gb.Grants(ctx, groups[1], token)
+	})
+	if got := grantPrincipals(grants); !equal(got, []string{"member:user-alan", "member:user-grace"}) {
+		t.Errorf("unexpected grants of group-b %v", got)
+	}
+	if memory.Calls("GetUserGroups") == 0 {
+		t.Error("expected auto to read memberships from the users")
+	}
+
+	// the strategy probe and the memberships read the pages the user syncer cached.
+	if got := memory.Calls("GetUsers"); got != pages {
+		t.Errorf("expected the memberships to reuse the %d user pages, got %d requests", pages, got)
+	}
+}
+
+func TestMembershipStrategyAPIVersion(t *testing.T) {
+	old := tableau.APIVersion{Major: 3, Minor: 6}
+	tests := []struct {
+		strategy   MembershipStrategy
+		apiVersion tableau.APIVersion
+		want       MembershipStrategy
+		wantErr    bool
+	}{
+		{strategy: MembershipByGroup, apiVersion: old, want: MembershipByGroup},
+		{strategy: MembershipAuto, apiVersion: old, want: MembershipByGroup},
+		{strategy: MembershipByUser, apiVersion: old, wantErr: true},
+		{strategy: MembershipAuto, apiVersion: tableau.UserGroupsAPIVersion, want: MembershipAuto},
+		{strategy: MembershipByUser, apiVersion: tableau.UserGroupsAPIVersion, want: MembershipByUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s on %s", tt.strategy, tt.apiVersion), func(t *testing.T) {
+			got, err := supportedMembershipStrategy(tt.strategy, tt.apiVersion)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("expected %q (error %v), got %q (%v)", tt.want, tt.wantErr, got, err)
+			}
+		})
+	}
+
+	// the strategy is checked when the connector starts, before any group is synced.
+	srv := newTestServer(t)
+	_, err := New(context.Background(), Config{
+		ServerURL:          srv.URL,
+		Auth:               &tableau.PersonalAccessToken{Name: "baton", Secret: "secret"},
+		APIVersion:         old,
+		MembershipStrategy: MembershipByUser,
+	})
+	if err == nil || !strings.Contains(err.Error(), "needs REST API 3.7") {
+		t.Errorf("expected the user strategy to be rejected on REST API 3.6, got %v", err)
+	}
+
+	tb := newTestConnectorWithConfig(t, srv, Config{APIVersion: old, MembershipStrategy: MembershipAuto})
+	if tb.membership != MembershipByGroup {
+		t.Errorf("expected auto to read memberships per group on REST API 3.6, got %q", tb.membership)
+	}
+}
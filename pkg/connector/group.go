@@ -19,7 +19,10 @@ const memberEntitlement = "member"
 
 type groupResourceType struct {
 	resourceType *v2.ResourceType
-	client       *tableau.Client
+	client       tableau.API
+	sites        *siteRegistry
+	cache        *syncCache
+	strategy     MembershipStrategy
 }
 
 func (g *groupResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -54,9 +57,15 @@ func (g *groupResourceType) List(ctx context.Context, parentId *v2.ResourceId, t
 		return nil, "", nil, nil
 	}
 
-	groups, err := g.client.GetPaginatedGroups(ctx)
+	client, err := g.sites.clientFor(ctx, g.client, parentId.Resource)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("tableau-connector: failed to list groups: %w", err)
+		return nil, "", nil, err
+	}
+
+	groups, nextToken, err := fetchPage(ctx, token, client.PageWorkers(), client.GetGroups)
+	annos := rateLimitAnnotations(client)
+	if err != nil {
+		return nil, "", annos, fmt.Errorf("tableau-connector: failed to list groups: %w", err)
 	}
 
 	var rv []*v2.Resource
@@ -69,7 +78,7 @@ func (g *groupResourceType) List(ctx context.Context, parentId *v2.ResourceId, t
 		rv = append(rv, ur)
 	}
 
-	return rv, "", nil, nil
+	return rv, nextToken, annos, nil
 }
 
 func (g *groupResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
@@ -100,23 +109,56 @@ func (g *groupResourceType) Grants(ctx context.Context, resource *v2.Resource, t
 		return nil, "", nil, fmt.Errorf("error fetching group_id from group profile")
 	}
 
-	users, err := g.client.GetPaginatedGroupUsers(ctx, groupId)
+	client, err := g.sites.clientFor(ctx, g.client, parentSiteID(resource))
 	if err != nil {
 		return nil, "", nil, err
 	}
 
+	strategy, err := g.membershipStrategy(ctx, client)
+	if err != nil {
+		return nil, "", rateLimitAnnotations(client), err
+	}
+	if strategy == MembershipByUser {
+		memberships, err := g.memberships(ctx, client)
+		annos := rateLimitAnnotations(client)
+		if err != nil {
+			return nil, "", annos, err
+		}
+
+		for _, userId := range memberships[groupId] {
+			principalId, err := rs.NewResourceID(resourceTypeUser, userId)
+			if err != nil {
+				return nil, "", nil, err
+			}
+
+			rv = append(rv, grant.NewGrant(resource, memberEntitlement, principalId))
+		}
+
+		return rv, "", annos, nil
+	}
+
+	// the members of a group are only read here, so their pages aren't cached.
+	fetch := func(ctx context.Context, pageSize int, pageNumber int) ([]tableau.User, tableau.Pagination, error) {
+		return client.GetGroupUsers(ctx, groupId, pageSize, pageNumber)
+	}
+
+	users, nextToken, err := fetchPage(ctx, token, client.PageWorkers(), fetch)
+	annos := rateLimitAnnotations(client)
+	if err != nil {
+		return nil, "", annos, err
+	}
+
 	for _, user := range users {
-		userCopy := user
-		ur, err := userResource(ctx, &userCopy, resource.Id)
+		principalId, err := rs.NewResourceID(resourceTypeUser, user.ID)
 		if err != nil {
 			return nil, "", nil, err
 		}
 
-		grant := grant.NewGrant(resource, memberEntitlement, ur.Id)
+		grant := grant.NewGrant(resource, memberEntitlement, principalId)
 		rv = append(rv, grant)
 	}
 
-	return rv, "", nil, nil
+	return rv, nextToken, annos, nil
 }
 
 func (o *groupResourceType) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
@@ -131,7 +173,14 @@ func (o *groupResourceType) Grant(ctx context.Context, principal *v2.Resource, e
 		return nil, fmt.Errorf("baton-tableau: only users can be granted group membership")
 	}
 
-	err := o.client.AddUserToGroup(ctx, entitlement.Resource.Id.Resource, principal.Id.Resource)
+	client, err := o.sites.clientFor(ctx, o.client, parentSiteID(entitlement.Resource))
+	if err != nil {
+		return nil, err
+	}
+
+	groupId := entitlement.Resource.Id.Resource
+	err = client.AddUserToGroup(ctx, groupId, principal.Id.Resource)
+	o.cache.invalidate(membershipsEndpoint(client.SiteID()))
 	if err != nil {
 		return nil, fmt.Errorf("baton-tableau: failed to add user to group: %w", err)
 	}
@@ -154,7 +203,14 @@ func (o *groupResourceType) Revoke(ctx context.Context, grant *v2.Grant) (annota
 		return nil, fmt.Errorf("baton-tableau: only users can have group membership revoked")
 	}
 
-	err := o.client.RemoveUserFromGroup(ctx, entitlement.Resource.Id.Resource, principal.Id.Resource)
+	client, err := o.sites.clientFor(ctx, o.client, parentSiteID(entitlement.Resource))
+	if err != nil {
+		return nil, err
+	}
+
+	groupId := entitlement.Resource.Id.Resource
+	err = client.RemoveUserFromGroup(ctx, groupId, principal.Id.Resource)
+	o.cache.invalidate(membershipsEndpoint(client.SiteID()))
 	if err != nil {
 		return nil, fmt.Errorf("baton-tableau: failed to remove user from group: %w", err)
 	}
@@ -162,9 +218,12 @@ func (o *groupResourceType) Revoke(ctx context.Context, grant *v2.Grant) (annota
 	return nil, nil
 }
 
-func groupBuilder(client *tableau.Client) *groupResourceType {
+func groupBuilder(client tableau.API, sites *siteRegistry, cache *syncCache, strategy MembershipStrategy) *groupResourceType {
 	return &groupResourceType{
 		resourceType: resourceTypeGroup,
 		client:       client,
+		sites:        sites,
+		cache:        cache,
+		strategy:     strategy,
 	}
 }
@@ -1,8 +1,14 @@
 package connector
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
 )
 
 func annotationsForUserResourceType() annotations.Annotations {
@@ -10,3 +16,59 @@ func annotationsForUserResourceType() annotations.Annotations {
 	annos.Update(&v2.SkipEntitlementsAndGrants{})
 	return annos
 }
+
+// rateLimitAnnotations lets the syncer pace itself based on how Tableau is throttling the client.
+func rateLimitAnnotations(client tableau.API) annotations.Annotations {
+	annos := annotations.Annotations{}
+	annos.WithRateLimiting(client.RateLimit())
+	return annos
+}
+
+// number of items requested per Tableau page.
+const resourcePageSize = 100
+
+// parsePageToken returns the Tableau page number encoded in a Baton page token.
+func parsePageToken(token *pagination.Token) (int, error) {
+	if token == nil || token.Token == "" {
+		return 1, nil
+	}
+
+	pageNumber, err := strconv.Atoi(token.Token)
+	if err != nil || pageNumber < 1 {
+		return 0, fmt.Errorf("tableau-connector: invalid page token %q", token.Token)
+	}
+
+	return pageNumber, nil
+}
+
+// formatPageToken encodes a Tableau page number as a Baton page token. Page 0 means there are no more pages.
+func formatPageToken(pageNumber int) string {
+	if pageNumber == 0 {
+		return ""
+	}
+	return strconv.Itoa(pageNumber)
+}
+
+// fetchPage reads the page referenced by the Baton page token and returns the token of the next page.
+// With more than one worker, the following pages are fetched concurrently and returned in the same call.
+func fetchPage[T any](ctx context.Context, token *pagination.Token, workers int, fetch tableau.PageFunc[T]) ([]T, string, error) {
+	pageNumber, err := parsePageToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	p := tableau.NewPaginatorAt(fetch, resourcePageSize, pageNumber,
+		tableau.WithWorkers(workers),
+		tableau.WithMaxPages(workers),
+	)
+
+	var rv []T
+	for p.Next(ctx) {
+		rv = append(rv, p.Page()...)
+	}
+	if err := p.Err(); err != nil {
+		return nil, "", err
+	}
+
+	return rv, formatPageToken(p.NextPageNumber()), nil
+}
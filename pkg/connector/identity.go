@@ -0,0 +1,63 @@
+package connector
+
+import (
+	"strings"
+
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+)
+
+// Domains of users that aren't imported from Active Directory: local on Tableau Server, external on Tableau Cloud.
+var nonDirectoryDomains = map[string]bool{
+	"":         true,
+	"local":    true,
+	"external": true,
+}
+
+// userIdentity is how a user is presented, picked consistently from its name, full name, email and domain.
+type userIdentity struct {
+	displayName string
+	firstName   string
+	lastName    string
+	// login is the name the user signs in with, DOMAIN\name for Active Directory users.
+	login string
+	email string
+}
+
+// normalizeIdentity derives the identity of a user. Tableau Cloud users are named by their email, so
+// the email falls back to the name, and users without a full name are displayed by their login.
+func normalizeIdentity(user *tableau.User) userIdentity {
+	name := strings.TrimSpace(user.Name)
+	fullName := strings.Join(strings.Fields(user.FullName), " ")
+
+	id := userIdentity{
+		login: name,
+		email: strings.TrimSpace(user.Email),
+	}
+
+	if id.email == "" && tableau.IsEmail(name) {
+		id.email = name
+	}
+
+	if domain := strings.TrimSpace(user.Domain.Name); name != "" && !strings.Contains(name, "@") &&
+		!nonDirectoryDomains[strings.ToLower(domain)] {
+		id.login = domain + `\` + name
+	}
+
+	if id.login == "" {
+		id.login = id.email
+	}
+
+	if fullName != "" {
+		id.displayName = fullName
+		id.firstName, id.lastName, _ = strings.Cut(fullName, " ")
+	}
+
+	for _, candidate := range []string{id.displayName, id.login, user.ID} {
+		if candidate != "" {
+			id.displayName = candidate
+			break
+		}
+	}
+
+	return id
+}
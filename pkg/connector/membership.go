@@ -0,0 +1,178 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+)
+
+// MembershipStrategy selects from which side group memberships are read. Every strategy produces the
+// same grants, they only differ in the number of requests sent to Tableau.
+type MembershipStrategy string
+
+const (
+	// MembershipByGroup lists the members of every group, at least one request per group.
+	MembershipByGroup MembershipStrategy = "group"
+	// MembershipByUser lists the groups of every user, at least one request per user. It is cheaper on
+	// sites with many small groups, and needs tableau.UserGroupsAPIVersion.
+	MembershipByUser MembershipStrategy = "user"
+	// MembershipAuto picks the strategy needing fewer requests on each site, from its user and group counts.
+	// It reads memberships per group on servers older than tableau.UserGroupsAPIVersion.
+	MembershipAuto MembershipStrategy = "auto"
+)
+
+// ParseMembershipStrategy parses a strategy name. An empty name selects MembershipByGroup.
+func ParseMembershipStrategy(value string) (MembershipStrategy, error) {
+	switch strategy := MembershipStrategy(value); strategy {
+	case "":
+		return MembershipByGroup, nil
+	case MembershipByGroup, MembershipByUser, MembershipAuto:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("tableau-connector: unknown membership strategy %q, expected group, user or auto", value)
+	}
+}
+
+// supportedMembershipStrategy checks the strategy against the REST API version. Reading memberships by user
+// needs the groups of a user, so auto falls back to MembershipByGroup on older versions and user is rejected.
+func supportedMembershipStrategy(strategy MembershipStrategy, apiVersion tableau.APIVersion) (MembershipStrategy, error) {
+	if apiVersion.AtLeast(tableau.UserGroupsAPIVersion) {
+		return strategy, nil
+	}
+
+	switch strategy {
+	case MembershipByUser:
+		return "", fmt.Errorf("tableau-connector: membership strategy user needs REST API %s, the server supports %s",
+			tableau.UserGroupsAPIVersion, apiVersion)
+	case MembershipAuto:
+		return MembershipByGroup, nil
+	default:
+		return strategy, nil
+	}
+}
+
+// membershipStrategy returns the strategy used on the site of the client, counting its users and groups
+// once per sync when the strategy is auto. The users are counted from their first page, which is shared
+// with the other readers of the users.
+func (g *groupResourceType) membershipStrategy(ctx context.Context, client tableau.API) (MembershipStrategy, error) {
+	if g.strategy != MembershipAuto {
+		return g.strategy, nil
+	}
+
+	return cachedValue(ctx, g.cache, sitesEndpoint(client.SiteID(), "membership-strategy"),
+		func(ctx context.Context) (MembershipStrategy, error) {
+			_, users, err := siteUsers(g.cache, client)(ctx, resourcePageSize, 1)
+			if err != nil {
+				return "", err
+			}
+			_, groups, err := client.GetGroups(ctx, 1, 1)
+			if err != nil {
+				return "", err
+			}
+
+			userCount, err := strconv.Atoi(users.TotalAvailable)
+			if err != nil {
+				return "", fmt.Errorf("tableau-connector: invalid user count %q: %w", users.TotalAvailable, err)
+			}
+			groupCount, err := strconv.Atoi(groups.TotalAvailable)
+			if err != nil {
+				return "", fmt.Errorf("tableau-connector: invalid group count %q: %w", groups.TotalAvailable, err)
+			}
+
+			if userCount < groupCount {
+				return MembershipByUser, nil
+			}
+			return MembershipByGroup, nil
+		},
+	)
+}
+
+// memberships returns the ids of the members of every group on the site of the client, read from the
+// groups of each user. It is built once per sync.
+func (g *groupResourceType) memberships(ctx context.Context, client tableau.API) (map[string][]string, error) {
+	return cachedValue(ctx, g.cache, membershipsEndpoint(client.SiteID()), func(ctx context.Context) (map[string][]string, error) {
+		users, err := tableau.NewPaginator(siteUsers(g.cache, client), resourcePageSize, tableau.WithWorkers(client.PageWorkers())).All(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		userGroups, err := fetchUserGroups(ctx, client, users)
+		if err != nil {
+			return nil, err
+		}
+
+		rv := make(map[string][]string)
+		for i, groups := range userGroups {
+			for _, group := range groups {
+				rv[group.ID] = append(rv[group.ID], users[i].ID)
+			}
+		}
+
+		return rv, nil
+	})
+}
+
+// fetchUserGroups returns the groups of each user, fetching the groups of up to PageWorkers users at once.
+func fetchUserGroups(ctx context.Context, client tableau.API, users []tableau.User) ([][]tableau.Group, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rv := make([][]tableau.Group, len(users))
+	indexes := make(chan int)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for w := 0; w < client.PageWorkers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				userId := users[i].ID
+				fetch := func(ctx context.Context, pageSize int, pageNumber int) ([]tableau.Group, tableau.Pagination, error) {
+					return client.GetUserGroups(ctx, userId, pageSize, pageNumber)
+				}
+
+				groups, err := tableau.NewPaginator(fetch, resourcePageSize).All(ctx)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("tableau-connector: failed to list groups of user %s: %w", userId, err)
+						cancel()
+					})
+					continue
+				}
+				rv[i] = groups
+			}
+		}()
+	}
+
+send:
+	for i := range users {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// membershipsEndpoint returns the cache key of the group memberships of a site.
+func membershipsEndpoint(siteId string) string {
+	return sitesEndpoint(siteId, "memberships")
+}
@@ -29,9 +29,13 @@ var roles = map[string]string{
 	"ReadOnly":                  "readonly",
 }
 
+const siteStateSuspended = "Suspended"
+
 type siteResourceType struct {
 	resourceType *v2.ResourceType
-	client       *tableau.Client
+	client       tableau.API
+	sites        *siteRegistry
+	cache        *syncCache
 }
 
 func (o *siteResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -54,19 +58,55 @@ func siteResource(site tableau.Site) (*v2.Resource, error) {
 	return ret, nil
 }
 
-func (o *siteResourceType) List(ctx context.Context, _ *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
-	var rv []*v2.Resource
-	site, err := o.client.GetSite(ctx)
-	if err != nil {
-		return nil, "", nil, err
+func (o *siteResourceType) List(ctx context.Context, _ *v2.ResourceId, token *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	// sites are the root of the resource tree, so listing them starts a new sync.
+	if token == nil || token.Token == "" {
+		o.cache.reset()
+	}
+
+	client := o.client
+	if !o.sites.allSites {
+		var rv []*v2.Resource
+		site, err := client.GetSite(ctx)
+		annos := rateLimitAnnotations(client)
+		if err != nil {
+			return nil, "", annos, err
+		}
+		sr, err := siteResource(site)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		rv = append(rv, sr)
+
+		return rv, "", annos, nil
 	}
-	sr, err := siteResource(site)
+
+	sites, nextToken, err := fetchPage(ctx, token, client.PageWorkers(), client.GetSites)
+	annos := rateLimitAnnotations(client)
 	if err != nil {
-		return nil, "", nil, err
+		return nil, "", annos, fmt.Errorf("tableau-connector: failed to list sites: %w", err)
 	}
-	rv = append(rv, sr)
 
-	return rv, "", nil, nil
+	var rv []*v2.Resource
+	for _, site := range sites {
+		// suspended sites can't be signed in to.
+		if site.State == siteStateSuspended {
+			ctxzap.Extract(ctx).Debug("tableau-connector: skipping suspended site",
+				zap.String("site_id", site.ID),
+				zap.String("site_content_url", site.ContentURL),
+			)
+			continue
+		}
+
+		o.sites.add(site)
+		sr, err := siteResource(site)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		rv = append(rv, sr)
+	}
+
+	return rv, nextToken, annos, nil
 }
 
 func (o *siteResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
@@ -85,10 +125,17 @@ func (o *siteResourceType) Entitlements(_ context.Context, resource *v2.Resource
 }
 
 func (o *siteResourceType) Grants(ctx context.Context, resource *v2.Resource, pt *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	users, err := o.client.GetPaginatedUsers(ctx)
+	client, err := o.sites.clientFor(ctx, o.client, resource.Id.Resource)
 	if err != nil {
 		return nil, "", nil, err
 	}
+
+	fetch := siteUsers(o.cache, client)
+	users, nextToken, err := fetchPage(ctx, pt, client.PageWorkers(), fetch)
+	annos := rateLimitAnnotations(client)
+	if err != nil {
+		return nil, "", annos, err
+	}
 	var rv []*v2.Grant
 	for _, user := range users {
 		roleName := roles[user.SiteRole]
@@ -98,21 +145,22 @@ func (o *siteResourceType) Grants(ctx context.Context, resource *v2.Resource, pt
 				zap.String("user", user.FullName),
 			)
 		}
-		userCopy := user
-		ur, err := userResource(ctx, &userCopy, resource.Id)
+		principalId, err := rs.NewResourceID(resourceTypeUser, user.ID)
 		if err != nil {
 			return nil, "", nil, err
 		}
 
-		permissionGrant := grant.NewGrant(resource, roleName, ur.Id)
+		permissionGrant := grant.NewGrant(resource, roleName, principalId)
 		rv = append(rv, permissionGrant)
 	}
-	return rv, "", nil, nil
+	return rv, nextToken, annos, nil
 }
 
-func siteBuilder(client *tableau.Client) *siteResourceType {
+func siteBuilder(client tableau.API, sites *siteRegistry, cache *syncCache) *siteResourceType {
 	return &siteResourceType{
 		resourceType: resourceTypeSite,
 		client:       client,
+		sites:        sites,
+		cache:        cache,
 	}
 }
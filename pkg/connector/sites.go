@@ -0,0 +1,80 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+)
+
+// siteRegistry keeps the sites found by the site listing, so syncers can send requests for the resources
+// of a site. Unless every site is synced, only the site the connector signed in to is known.
+type siteRegistry struct {
+	mtx      sync.Mutex
+	allSites bool
+	sites    map[string]tableau.Site
+}
+
+func newSiteRegistry(allSites bool) *siteRegistry {
+	return &siteRegistry{
+		allSites: allSites,
+		sites:    make(map[string]tableau.Site),
+	}
+}
+
+// add registers a site returned by the site listing.
+func (r *siteRegistry) add(site tableau.Site) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.sites[site.ID] = site
+}
+
+// clientFor returns the API for the site, derived from the client of the signed in site. An empty site id
+// returns client itself.
+func (r *siteRegistry) clientFor(ctx context.Context, client tableau.API, siteId string) (tableau.API, error) {
+	if siteId == "" || siteId == client.SiteID() {
+		return client, nil
+	}
+
+	if site, ok := r.lookup(siteId); ok {
+		return client.ForSite(site), nil
+	}
+
+	if !r.allSites {
+		return nil, fmt.Errorf("tableau-connector: site %s is not the site the connector signed in to", siteId)
+	}
+
+	// the sync may have been resumed without listing sites first.
+	sites, err := tableau.NewPaginator(client.GetSites, resourcePageSize, tableau.WithWorkers(client.PageWorkers())).All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tableau-connector: failed to list sites: %w", err)
+	}
+	for _, site := range sites {
+		r.add(site)
+	}
+
+	if site, ok := r.lookup(siteId); ok {
+		return client.ForSite(site), nil
+	}
+
+	return nil, fmt.Errorf("tableau-connector: site %s not found", siteId)
+}
+
+func (r *siteRegistry) lookup(siteId string) (tableau.Site, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	site, ok := r.sites[siteId]
+	return site, ok
+}
+
+// parentSiteID returns the id of the site a user or group belongs to, or an empty id if it has no parent.
+func parentSiteID(resource *v2.Resource) string {
+	if resource == nil || resource.ParentResourceId == nil {
+		return ""
+	}
+	return resource.ParentResourceId.Resource
+}
@@ -0,0 +1,327 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/dotc1z"
+	sdkSync "github.com/conductorone/baton-sdk/pkg/sync"
+	"github.com/conductorone/baton-sdk/pkg/types"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+	"github.com/conductorone/baton-tableau/pkg/tableau/tableautest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestSyncGolden runs a full Baton sync against the fixture and compares the synced resources, entitlements
+// and grants to testdata/golden. Run `go test ./pkg/connector -run TestSyncGolden -update` to accept changes.
+func TestSyncGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		golden string
+		cfg    Config
+	}{
+		{name: "site", golden: "site", cfg: Config{}},
+		{name: "all-sites", golden: "all-sites", cfg: Config{AllSites: true}},
+		// every membership strategy has to produce the same grants.
+		{name: "site-by-user", golden: "site", cfg: Config{MembershipStrategy: MembershipByUser}},
+		{name: "all-sites-by-user", golden: "all-sites", cfg: Config{AllSites: true, MembershipStrategy: MembershipByUser}},
+		{name: "all-sites-auto", golden: "all-sites", cfg: Config{AllSites: true, MembershipStrategy: MembershipAuto}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			tb := newTestConnectorWithConfig(t, srv, tt.cfg)
+
+			got := syncSnapshot(t, tb)
+
+			golden := filepath.Join("testdata", "golden", tt.golden+".json")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("reading golden file, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("sync of %s differs from %s, run with -update to accept the changes:\n%s", tt.name, golden, got)
+			}
+		})
+	}
+}
+
+// syncSnapshot syncs the connector into a c1z file and returns its resources, entitlements and grants as
+// indented JSON. Each list is sorted by id, so the output doesn't depend on the order of the sync.
+func syncSnapshot(t *testing.T, tb *Tableau) []byte {
+	t.Helper()
+	ctx := context.Background()
+
+	store := runSync(t, &connectorClient{server: newConnectorServer(t, tb)})
+	defer store.Close()
+
+	snapshot := map[string][]snapshotEntry{}
+
+	var token string
+	for {
+		resp, err := store.ListResources(ctx, &v2.ResourcesServiceListResourcesRequest{PageToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range resp.List {
+			snapshot["resources"] = append(snapshot["resources"], snapshotEntry{
+				id:   r.Id.ResourceType + ":" + r.Id.Resource,
+				data: marshalSorted(t, r),
+			})
+		}
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+	}
+
+	for {
+		resp, err := store.ListEntitlements(ctx, &v2.EntitlementsServiceListEntitlementsRequest{PageToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range resp.List {
+			snapshot["entitlements"] = append(snapshot["entitlements"], snapshotEntry{id: e.Id, data: marshalSorted(t, e)})
+		}
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+	}
+
+	for {
+		resp, err := store.ListGrants(ctx, &v2.GrantsServiceListGrantsRequest{PageToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, g := range resp.List {
+			snapshot["grants"] = append(snapshot["grants"], snapshotEntry{id: g.Id, data: marshalSorted(t, g)})
+		}
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+	}
+
+	sorted := make(map[string][]json.RawMessage, len(snapshot))
+	for name, list := range snapshot {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].id < list[j].id
+		})
+		for _, entry := range list {
+			sorted[name] = append(sorted[name], entry.data)
+		}
+	}
+
+	out, err := json.MarshalIndent(sorted, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(out, '\n')
+}
+
+// newConnectorServer returns the connector server the Baton SDK builds around tb.
+func newConnectorServer(t *testing.T, tb *Tableau) types.ConnectorServer {
+	t.Helper()
+
+	server, err := connectorbuilder.NewConnector(context.Background(), tb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// runSync runs a Baton sync through client and returns the synced c1z file.
+func runSync(t *testing.T, client types.ConnectorClient) *dotc1z.C1File {
+	t.Helper()
+	ctx := context.Background()
+
+	c1zPath := filepath.Join(t.TempDir(), "sync.c1z")
+	syncer, err := sdkSync.NewSyncer(ctx, client, sdkSync.WithC1ZPath(c1zPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.Sync(ctx); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if err := syncer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := dotc1z.NewC1ZFile(ctx, c1zPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// TestSyncBoundsCachedPages checks the user pages cached during a sync stay bounded, although the Baton
+// syncer lists every user before it lists the first grant.
+func TestSyncBoundsCachedPages(t *testing.T) {
+	const users = 60
+
+	site := tableautest.Site{Site: tableau.Site{ID: "site-default", Name: "Default", State: "Active"}}
+	for i := 0; i < users; i++ {
+		site.Users = append(site.Users, tableau.User{
+			ID:       fmt.Sprintf("user-%03d", i),
+			Name:     fmt.Sprintf("user%03d", i),
+			SiteRole: "Viewer",
+		})
+	}
+	site.Groups = []tableautest.Group{
+		{Group: tableau.Group{ID: "group-all", Name: "All Users"}, Members: []string{"user-000", "user-059"}},
+	}
+
+	// pages of 2 users, so the site has more pages than the cache keeps.
+	srv := tableautest.NewServer(tableautest.Fixture{Sites: []tableautest.Site{site}}, tableautest.WithMaxPageSize(2))
+	t.Cleanup(srv.Close)
+	tb := newTestConnectorWithConfig(t, srv, Config{})
+
+	cachedPages := -1
+	client := &grantsHookClient{
+		connectorClient: connectorClient{server: newConnectorServer(t, tb)},
+		firstGrants: func() {
+			tb.cache.mtx.Lock()
+			cachedPages = tb.cache.pages.Len()
+			tb.cache.mtx.Unlock()
+		},
+	}
+
+	store := runSync(t, client)
+	defer store.Close()
+
+	if cachedPages < 0 {
+		t.Fatal("expected the syncer to list grants")
+	}
+	if cachedPages == 0 || cachedPages > defaultMaxCachedPages {
+		t.Errorf("expected at most %d cached pages after the resource phase, got %d", defaultMaxCachedPages, cachedPages)
+	}
+
+	// the site role grants read the pages dropped from the cache again.
+	siteGrants := 0
+	var token string
+	for {
+		resp, err := store.ListGrants(context.Background(), &v2.GrantsServiceListGrantsRequest{PageToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, g := range resp.List {
+			if g.Entitlement.Resource.Id.ResourceType == resourceTypeSite.Id {
+				siteGrants++
+			}
+		}
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+	}
+	if siteGrants != users {
+		t.Errorf("expected a site role grant for each of the %d users, got %d", users, siteGrants)
+	}
+}
+
+// grantsHookClient calls firstGrants before the first ListGrants call, when the syncer listed every resource.
+type grantsHookClient struct {
+	connectorClient
+	once        sync.Once
+	firstGrants func()
+}
+
+func (c *grantsHookClient) ListGrants(ctx context.Context, in *v2.GrantsServiceListGrantsRequest, opts ...grpc.CallOption) (*v2.GrantsServiceListGrantsResponse, error) {
+	c.once.Do(c.firstGrants)
+	return c.connectorClient.ListGrants(ctx, in, opts...)
+}
+
+type snapshotEntry struct {
+	id   string
+	data json.RawMessage
+}
+
+// marshalSorted returns the message as JSON with sorted keys, since protojson output isn't stable.
+func marshalSorted(t *testing.T, msg proto.Message) json.RawMessage {
+	t.Helper()
+
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+// connectorClient calls the connector server in process, so the syncer can run without gRPC.
+type connectorClient struct {
+	server types.ConnectorServer
+}
+
+var _ types.ConnectorClient = (*connectorClient)(nil)
+
+func (c *connectorClient) ListResourceTypes(ctx context.Context, in *v2.ResourceTypesServiceListResourceTypesRequest, _ ...grpc.CallOption) (*v2.ResourceTypesServiceListResourceTypesResponse, error) {
+	return c.server.ListResourceTypes(ctx, in)
+}
+
+func (c *connectorClient) ListResources(ctx context.Context, in *v2.ResourcesServiceListResourcesRequest, _ ...grpc.CallOption) (*v2.ResourcesServiceListResourcesResponse, error) {
+	return c.server.ListResources(ctx, in)
+}
+
+func (c *connectorClient) ListEntitlements(ctx context.Context, in *v2.EntitlementsServiceListEntitlementsRequest, _ ...grpc.CallOption) (*v2.EntitlementsServiceListEntitlementsResponse, error) {
+	return c.server.ListEntitlements(ctx, in)
+}
+
+func (c *connectorClient) ListGrants(ctx context.Context, in *v2.GrantsServiceListGrantsRequest, _ ...grpc.CallOption) (*v2.GrantsServiceListGrantsResponse, error) {
+	return c.server.ListGrants(ctx, in)
+}
+
+func (c *connectorClient) GetMetadata(ctx context.Context, in *v2.ConnectorServiceGetMetadataRequest, _ ...grpc.CallOption) (*v2.ConnectorServiceGetMetadataResponse, error) {
+	return c.server.GetMetadata(ctx, in)
+}
+
+func (c *connectorClient) Validate(ctx context.Context, in *v2.ConnectorServiceValidateRequest, _ ...grpc.CallOption) (*v2.ConnectorServiceValidateResponse, error) {
+	return c.server.Validate(ctx, in)
+}
+
+func (c *connectorClient) GetAsset(_ context.Context, _ *v2.AssetServiceGetAssetRequest, _ ...grpc.CallOption) (v2.AssetService_GetAssetClient, error) {
+	return nil, status.Error(codes.Unimplemented, "assets are not synced by the connector")
+}
+
+func (c *connectorClient) Grant(ctx context.Context, in *v2.GrantManagerServiceGrantRequest, _ ...grpc.CallOption) (*v2.GrantManagerServiceGrantResponse, error) {
+	return c.server.Grant(ctx, in)
+}
+
+func (c *connectorClient) Revoke(ctx context.Context, in *v2.GrantManagerServiceRevokeRequest, _ ...grpc.CallOption) (*v2.GrantManagerServiceRevokeResponse, error) {
+	return c.server.Revoke(ctx, in)
+}
@@ -2,51 +2,131 @@ package connector
 
 import (
 	"context"
-	"strings"
+	"time"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
 	"github.com/conductorone/baton-tableau/pkg/tableau"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
 )
 
+const siteRoleUnlicensed = "Unlicensed"
+
+// Reasons recorded in the status_reason field of user profiles.
+const (
+	statusReasonActive        = "active"
+	statusReasonUnlicensed    = "unlicensed"
+	statusReasonInactive      = "inactive"
+	statusReasonNeverSignedIn = "never_signed_in"
+)
+
+// userOptions configures how Tableau users are turned into resources.
+type userOptions struct {
+	// inactiveAfter disables users who haven't signed in for longer. Zero disables the check.
+	inactiveAfter time.Duration
+	// now returns the current time, it defaults to time.Now.
+	now func() time.Time
+	// accountTypes classifies users as human, service or system accounts.
+	accountTypes *accountTypes
+	// connectorUserID is the id of the user the connector signs in to the site as.
+	connectorUserID string
+}
+
 type userResourceType struct {
 	resourceType *v2.ResourceType
-	client       *tableau.Client
+	client       tableau.API
+	sites        *siteRegistry
+	cache        *syncCache
+	opts         userOptions
 }
 
 func (o *userResourceType) ResourceType(_ context.Context) *v2.ResourceType {
 	return o.resourceType
 }
 
-// Create a new connector resource for a Tableau user.
-func userResource(ctx context.Context, user *tableau.User, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
-	names := strings.SplitN(user.FullName, " ", 2)
-	var firstName, lastName string
-	switch len(names) {
-	case 1:
-		firstName = names[0]
-	case 2:
-		firstName = names[0]
-		lastName = names[1]
+// userStatus returns the status of the user and the reason for it. Unlicensed users are disabled, and so
+// are users who haven't signed in within opts.inactiveAfter when it is set.
+func userStatus(ctx context.Context, user *tableau.User, opts userOptions) (v2.UserTrait_Status_Status, string) {
+	if user.SiteRole == siteRoleUnlicensed {
+		return v2.UserTrait_Status_STATUS_DISABLED, statusReasonUnlicensed
+	}
+
+	if opts.inactiveAfter <= 0 {
+		return v2.UserTrait_Status_STATUS_ENABLED, statusReasonActive
+	}
+
+	if user.LastLogin == "" {
+		return v2.UserTrait_Status_STATUS_DISABLED, statusReasonNeverSignedIn
+	}
+
+	lastLogin, err := time.Parse(time.RFC3339, user.LastLogin)
+	if err != nil {
+		ctxzap.Extract(ctx).Warn(
+			"tableau-connector: unable to parse last login, treating user as active",
+			zap.String("user_id", user.ID),
+			zap.String("last_login", user.LastLogin),
+			zap.Error(err),
+		)
+		return v2.UserTrait_Status_STATUS_ENABLED, statusReasonActive
+	}
+
+	now := time.Now
+	if opts.now != nil {
+		now = opts.now
 	}
+	if now().Sub(lastLogin) > opts.inactiveAfter {
+		return v2.UserTrait_Status_STATUS_DISABLED, statusReasonInactive
+	}
+
+	return v2.UserTrait_Status_STATUS_ENABLED, statusReasonActive
+}
+
+// Create a new connector resource for a Tableau user.
+func userResource(ctx context.Context, user *tableau.User, parentResourceID *v2.ResourceId, opts userOptions) (*v2.Resource, error) {
+	identity := normalizeIdentity(user)
 
 	profile := map[string]interface{}{
-		"first_name": firstName,
-		"last_name":  lastName,
-		"login":      user.Email,
+		"first_name": identity.firstName,
+		"last_name":  identity.lastName,
+		"login":      identity.login,
 		"user_id":    user.ID,
 	}
 
+	// the SDK has no last login field on the user trait yet, so it is only part of the profile.
+	for key, value := range map[string]string{
+		"last_login":            user.LastLogin,
+		"auth_setting":          user.AuthSetting,
+		"locale":                user.Locale,
+		"language":              user.Language,
+		"domain":                user.Domain.Name,
+		"external_auth_user_id": user.ExternalAuthUserID,
+		"idp_configuration_id":  user.IdpConfigurationID,
+	} {
+		if value != "" {
+			profile[key] = value
+		}
+	}
+
+	status, reason := userStatus(ctx, user, opts)
+	profile["status_reason"] = reason
+
+	connectorUser := user.ID != "" && user.ID == opts.connectorUserID
+	if connectorUser {
+		profile["connector_user"] = true
+	}
+
 	userTraitOptions := []rs.UserTraitOption{
 		rs.WithUserProfile(profile),
-		rs.WithEmail(user.Email, true),
-		rs.WithStatus(v2.UserTrait_Status_STATUS_ENABLED),
+		rs.WithEmail(identity.email, true),
+		rs.WithStatus(status),
+		rs.WithAccountType(opts.accountTypes.accountType(user, connectorUser)),
 	}
 
 	ret, err := rs.NewUserResource(
-		user.FullName,
+		identity.displayName,
 		resourceTypeUser,
 		user.ID,
 		userTraitOptions,
@@ -64,22 +144,33 @@ func (o *userResourceType) List(ctx context.Context, parentId *v2.ResourceId, to
 		return nil, "", nil, nil
 	}
 
-	users, err := o.client.GetPaginatedUsers(ctx)
+	client, err := o.sites.clientFor(ctx, o.client, parentId.Resource)
 	if err != nil {
 		return nil, "", nil, err
 	}
 
+	fetch := siteUsers(o.cache, client)
+	users, nextToken, err := fetchPage(ctx, token, client.PageWorkers(), fetch)
+	annos := rateLimitAnnotations(client)
+	if err != nil {
+		return nil, "", annos, err
+	}
+
+	// the session signed in to the site while fetching the page, so its user on the site is known.
+	opts := o.opts
+	opts.connectorUserID = client.SignedInUserID()
+
 	var rv []*v2.Resource
 	for _, user := range users {
 		userCopy := user
-		ur, err := userResource(ctx, &userCopy, parentId)
+		ur, err := userResource(ctx, &userCopy, parentId, opts)
 		if err != nil {
 			return nil, "", nil, err
 		}
 		rv = append(rv, ur)
 	}
 
-	return rv, "", nil, nil
+	return rv, nextToken, annos, nil
 }
 
 func (o *userResourceType) Entitlements(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
@@ -90,9 +181,12 @@ func (o *userResourceType) Grants(_ context.Context, _ *v2.Resource, _ *paginati
 	return nil, "", nil, nil
 }
 
-func userBuilder(client *tableau.Client) *userResourceType {
+func userBuilder(client tableau.API, sites *siteRegistry, cache *syncCache, opts userOptions) *userResourceType {
 	return &userResourceType{
 		resourceType: resourceTypeUser,
 		client:       client,
+		sites:        sites,
+		cache:        cache,
+		opts:         opts,
 	}
 }
@@ -0,0 +1,35 @@
+package tableau
+
+import (
+	"context"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+)
+
+// API is the part of the Tableau REST API used by the connector. Client implements it against a Tableau
+// server, tableautest.Memory implements it in memory for tests.
+type API interface {
+	// SiteID returns the id of the site requests are sent to.
+	SiteID() string
+	// ForSite returns an API for another site on the same server.
+	ForSite(site Site) API
+	// SignedInUserID returns the id of the user the session is signed in to the site as, or "" before
+	// the first request to the site.
+	SignedInUserID() string
+	// PageWorkers returns how many pages of a list endpoint are fetched concurrently.
+	PageWorkers() int
+	// RateLimit describes the current rate limit status.
+	RateLimit() *v2.RateLimitDescription
+
+	VerifyUser(ctx context.Context) error
+	GetSite(ctx context.Context) (Site, error)
+	GetSites(ctx context.Context, pageSize int, pageNumber int) ([]Site, Pagination, error)
+	GetUsers(ctx context.Context, pageSize int, pageNumber int) ([]User, Pagination, error)
+	GetGroups(ctx context.Context, pageSize int, pageNumber int) ([]Group, Pagination, error)
+	GetGroupUsers(ctx context.Context, groupId string, pageSize int, pageNumber int) ([]User, Pagination, error)
+	GetUserGroups(ctx context.Context, userId string, pageSize int, pageNumber int) ([]Group, Pagination, error)
+	AddUserToGroup(ctx context.Context, groupId string, userId string) error
+	RemoveUserFromGroup(ctx context.Context, groupId string, userId string) error
+}
+
+var _ API = (*Client)(nil)
@@ -0,0 +1,242 @@
+package tableau
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/google/uuid"
+)
+
+const (
+	// Tableau rejects connected app tokens that are valid for longer than 10 minutes.
+	connectedAppTokenLifetime = 5 * time.Minute
+	// DefaultConnectedAppAudience is the audience Tableau expects in connected app tokens.
+	DefaultConnectedAppAudience = "tableau"
+)
+
+// DefaultConnectedAppScopes are the scopes required to sync and provision users and groups.
+var DefaultConnectedAppScopes = []string{
+	"tableau:sites:read",
+	"tableau:users:*",
+	"tableau:groups:*",
+}
+
+// Authenticator provides the credentials sent to the sign-in endpoint.
+type Authenticator interface {
+	// SignInCredentials returns the credentials object without the site attribute.
+	SignInCredentials(ctx context.Context) (map[string]interface{}, error)
+}
+
+// PersonalAccessToken signs in using a personal access token.
+type PersonalAccessToken struct {
+	Name   string
+	Secret string
+}
+
+func (p *PersonalAccessToken) SignInCredentials(_ context.Context) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"personalAccessTokenName":   p.Name,
+		"personalAccessTokenSecret": p.Secret,
+	}, nil
+}
+
+// UsernamePassword signs in using the name and password of a Tableau Server user. Server administrators
+// can set ImpersonateUserID to sign in as another user.
+type UsernamePassword struct {
+	Username          string
+	Password          string
+	ImpersonateUserID string
+}
+
+func (u *UsernamePassword) SignInCredentials(_ context.Context) (map[string]interface{}, error) {
+	credentials := map[string]interface{}{
+		"name":     u.Username,
+		"password": u.Password,
+	}
+
+	if u.ImpersonateUserID != "" {
+		credentials["user"] = map[string]string{
+			"id": u.ImpersonateUserID,
+		}
+	}
+
+	return credentials, nil
+}
+
+// String keeps the password out of logs and error messages.
+func (u *UsernamePassword) String() string {
+	return fmt.Sprintf("UsernamePassword{Username: %q, ImpersonateUserID: %q}", u.Username, u.ImpersonateUserID)
+}
+
+// ConnectedApp signs in using a JWT signed with the secret of a direct trust connected app.
+type ConnectedApp struct {
+	ClientID    string
+	SecretID    string
+	SecretValue string
+	Username    string
+	Scopes      []string
+}
+
+func (c *ConnectedApp) SignInCredentials(_ context.Context) (map[string]interface{}, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: []byte(c.SecretValue)},
+		(&jose.SignerOptions{}).
+			WithType("JWT").
+			WithHeader("kid", c.SecretID).
+			WithHeader("iss", c.ClientID),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tableau-connector: failed to create jwt signer: %w", err)
+	}
+
+	token, err := signJWT(signer, c.ClientID, DefaultConnectedAppAudience, c.Username, c.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"jwt": token,
+	}, nil
+}
+
+// ExternalAuthorizationServer signs in using a JWT signed with the private key of an
+// external authorization server registered with the site.
+type ExternalAuthorizationServer struct {
+	Key      crypto.Signer
+	KeyID    string
+	Issuer   string
+	Audience string
+	Subject  string
+	Scopes   []string
+}
+
+func (e *ExternalAuthorizationServer) SignInCredentials(_ context.Context) (map[string]interface{}, error) {
+	algorithm, err := signatureAlgorithm(e.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	options := (&jose.SignerOptions{}).WithType("JWT")
+	if e.KeyID != "" {
+		options = options.WithHeader("kid", e.KeyID)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: e.Key}, options)
+	if err != nil {
+		return nil, fmt.Errorf("tableau-connector: failed to create jwt signer: %w", err)
+	}
+
+	audience := e.Audience
+	if audience == "" {
+		audience = DefaultConnectedAppAudience
+	}
+
+	token, err := signJWT(signer, e.Issuer, audience, e.Subject, e.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"jwt": token,
+	}, nil
+}
+
+// LoadPrivateKey reads a PEM encoded RSA or EC private key from disk.
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tableau-connector: failed to read private key: %w", err)
+	}
+
+	return ParsePrivateKey(data)
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS#1, PKCS#8 or SEC 1 private key.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("tableau-connector: private key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tableau-connector: failed to parse private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("tableau-connector: unsupported private key type %T", key)
+	}
+
+	if _, err := signatureAlgorithm(signer); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// signatureAlgorithm picks the JWS algorithm matching the private key.
+func signatureAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+		return "", fmt.Errorf("tableau-connector: unsupported elliptic curve %s", k.Curve.Params().Name)
+	default:
+		return "", fmt.Errorf("tableau-connector: unsupported private key type %T", key)
+	}
+}
+
+// signJWT returns a compact serialized JWT with the claims expected by Tableau.
+func signJWT(signer jose.Signer, issuer string, audience string, subject string, scopes []string) (string, error) {
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:   issuer,
+		Subject:  subject,
+		Audience: jwt.Audience{audience},
+		ID:       uuid.NewString(),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(connectedAppTokenLifetime)),
+	}
+
+	if len(scopes) == 0 {
+		scopes = DefaultConnectedAppScopes
+	}
+
+	token, err := jwt.Signed(signer).
+		Claims(claims).
+		Claims(map[string]interface{}{"scp": scopes}).
+		CompactSerialize()
+	if err != nil {
+		return "", fmt.Errorf("tableau-connector: failed to sign jwt: %w", err)
+	}
+
+	return token, nil
+}
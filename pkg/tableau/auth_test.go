@@ -0,0 +1,316 @@
+package tableau_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// tokenClaims are the claims Tableau reads from connected app and external authorization server tokens.
+type tokenClaims struct {
+	jwt.Claims
+	Scopes []string `json:"scp"`
+}
+
+// parseToken parses the jwt of the sign-in credentials and verifies its signature with key.
+func parseToken(t *testing.T, credentials map[string]interface{}, key interface{}) (*jwt.JSONWebToken, tokenClaims) {
+	t.Helper()
+
+	raw, ok := credentials["jwt"].(string)
+	if !ok || len(credentials) != 1 {
+		t.Fatalf("expected credentials with only a jwt, got %v", credentials)
+	}
+
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		t.Fatalf("parsing jwt: %v", err)
+	}
+	if len(token.Headers) != 1 {
+		t.Fatalf("expected one signature, got %d", len(token.Headers))
+	}
+
+	var claims tokenClaims
+	if err := token.Claims(key, &claims); err != nil {
+		t.Fatalf("verifying jwt: %v", err)
+	}
+
+	return token, claims
+}
+
+// checkClaims checks the registered claims and that the token expires within the 10 minutes Tableau accepts.
+func checkClaims(t *testing.T, claims tokenClaims, issuer string, subject string, audience string) {
+	t.Helper()
+
+	now := time.Now()
+	expected := jwt.Expected{Issuer: issuer, Subject: subject, Audience: jwt.Audience{audience}, Time: now}
+	if err := claims.ValidateWithLeeway(expected, 0); err != nil {
+		t.Errorf("unexpected claims %+v: %v", claims.Claims, err)
+	}
+	if claims.ID == "" {
+		t.Error("expected a jti claim")
+	}
+	if claims.Expiry == nil || claims.IssuedAt == nil {
+		t.Fatalf("expected exp and iat claims, got %+v", claims.Claims)
+	}
+	if lifetime := claims.Expiry.Time().Sub(claims.IssuedAt.Time()); lifetime <= 0 || lifetime > 10*time.Minute {
+		t.Errorf("expected a lifetime of at most 10 minutes, got %v", lifetime)
+	}
+}
+
+func TestConnectedAppToken(t *testing.T) {
+	app := &tableau.ConnectedApp{
+		ClientID:    "client-id",
+		SecretID:    "secret-id",
+		SecretValue: "0123456789abcdef0123456789abcdef",
+		Username:    "admin@example.com",
+	}
+
+	credentials, err := app.SignInCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, claims := parseToken(t, credentials, []byte(app.SecretValue))
+
+	header := token.Headers[0]
+	if header.Algorithm != string(jose.HS256) || header.KeyID != "secret-id" {
+		t.Errorf("expected HS256 signed with kid secret-id, got %s with kid %q", header.Algorithm, header.KeyID)
+	}
+	if iss := header.ExtraHeaders["iss"]; iss != "client-id" {
+		t.Errorf("expected iss header client-id, got %v", iss)
+	}
+	if typ := header.ExtraHeaders[jose.HeaderType]; typ != "JWT" {
+		t.Errorf("expected typ header JWT, got %v", typ)
+	}
+
+	checkClaims(t, claims, "client-id", "admin@example.com", tableau.DefaultConnectedAppAudience)
+	if strings.Join(claims.Scopes, " ") != strings.Join(tableau.DefaultConnectedAppScopes, " ") {
+		t.Errorf("expected the default scopes, got %v", claims.Scopes)
+	}
+
+	// every token gets a new id, Tableau rejects replayed ones.
+	again, err := app.SignInCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, next := parseToken(t, again, []byte(app.SecretValue)); next.ID == claims.ID {
+		t.Errorf("expected a new jti for every token, got %s twice", claims.ID)
+	}
+
+	app.Scopes = []string{"tableau:users:read"}
+	credentials, err = app.SignInCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, claims := parseToken(t, credentials, []byte(app.SecretValue)); len(claims.Scopes) != 1 || claims.Scopes[0] != "tableau:users:read" {
+		t.Errorf("expected the configured scopes, got %v", claims.Scopes)
+	}
+
+	if err := verifyWith(credentials, []byte("another secret of the same length!")); err == nil {
+		t.Error("expected the token to be rejected with another secret")
+	}
+}
+
+func verifyWith(credentials map[string]interface{}, key interface{}) error {
+	token, err := jwt.ParseSigned(credentials["jwt"].(string))
+	if err != nil {
+		return err
+	}
+	var claims tokenClaims
+	return token.Claims(key, &claims)
+}
+
+func TestExternalAuthorizationServerToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           crypto.Signer
+		keyID         string
+		audience      string
+		wantAlgorithm jose.SignatureAlgorithm
+		wantAudience  string
+	}{
+		{name: "rsa", key: rsaKey(t), keyID: "key-1", wantAlgorithm: jose.RS256, wantAudience: tableau.DefaultConnectedAppAudience},
+		{name: "p256", key: ecKey(t, elliptic.P256()), keyID: "key-2", wantAlgorithm: jose.ES256, wantAudience: tableau.DefaultConnectedAppAudience},
+		{name: "p384", key: ecKey(t, elliptic.P384()), audience: "custom", wantAlgorithm: jose.ES384, wantAudience: "custom"},
+		{name: "p521", key: ecKey(t, elliptic.P521()), wantAlgorithm: jose.ES512, wantAudience: tableau.DefaultConnectedAppAudience},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eas := &tableau.ExternalAuthorizationServer{
+				Key:      tt.key,
+				KeyID:    tt.keyID,
+				Issuer:   "https://idp.example.com",
+				Audience: tt.audience,
+				Subject:  "admin@example.com",
+				Scopes:   []string{"tableau:sites:read"},
+			}
+
+			credentials, err := eas.SignInCredentials(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, claims := parseToken(t, credentials, tt.key.Public())
+
+			header := token.Headers[0]
+			if header.Algorithm != string(tt.wantAlgorithm) {
+				t.Errorf("expected algorithm %s, got %s", tt.wantAlgorithm, header.Algorithm)
+			}
+			if header.KeyID != tt.keyID {
+				t.Errorf("expected kid %q, got %q", tt.keyID, header.KeyID)
+			}
+			if _, ok := header.ExtraHeaders["iss"]; ok {
+				t.Error("expected no iss header, it is only sent by connected apps")
+			}
+
+			checkClaims(t, claims, "https://idp.example.com", "admin@example.com", tt.wantAudience)
+			if len(claims.Scopes) != 1 || claims.Scopes[0] != "tableau:sites:read" {
+				t.Errorf("expected the configured scopes, got %v", claims.Scopes)
+			}
+		})
+	}
+
+	unsupported := &tableau.ExternalAuthorizationServer{Key: ecKey(t, elliptic.P224()), Issuer: "issuer", Subject: "admin"}
+	if _, err := unsupported.SignInCredentials(context.Background()); err == nil {
+		t.Error("expected a P-224 key to be rejected")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaPrivate := rsaKey(t)
+	p256 := ecKey(t, elliptic.P256())
+	p384 := ecKey(t, elliptic.P384())
+	p224 := ecKey(t, elliptic.P224())
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec1 := func(key *ecdsa.PrivateKey) []byte {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pemBlock("EC PRIVATE KEY", der)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    crypto.Signer
+		wantErr string
+	}{
+		{name: "pkcs1 rsa", data: pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPrivate)), want: rsaPrivate},
+		{name: "pkcs8 rsa", data: pkcs8(t, rsaPrivate), want: rsaPrivate},
+		{name: "pkcs8 ec", data: pkcs8(t, p256), want: p256},
+		{name: "sec1 ec", data: sec1(p384), want: p384},
+		{name: "not pem", data: []byte("not a key"), wantErr: "not PEM encoded"},
+		{name: "invalid der", data: pemBlock("PRIVATE KEY", []byte("garbage")), wantErr: "failed to parse private key"},
+		{name: "pkcs1 block with pkcs8 key", data: pemBlock("RSA PRIVATE KEY", pkcs8DER(t, rsaPrivate)), wantErr: "failed to parse private key"},
+		{name: "public key", data: pemBlock("PUBLIC KEY", publicDER(t, rsaPrivate)), wantErr: "failed to parse private key"},
+		{name: "ed25519", data: pkcs8(t, edPrivate), wantErr: "unsupported private key type"},
+		{name: "p224", data: sec1(p224), wantErr: "unsupported elliptic curve"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tableau.ParsePrivateKey(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			equal, ok := key.(interface{ Equal(crypto.PrivateKey) bool })
+			if !ok || !equal.Equal(tt.want) {
+				t.Errorf("expected the encoded key, got %T", key)
+			}
+		})
+	}
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	key := ecKey(t, elliptic.P256())
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pkcs8(t, key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := tableau.LoadPrivateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(loaded) {
+		t.Error("expected the key written to disk")
+	}
+
+	if _, err := tableau.LoadPrivateKey(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func ecKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func pemBlock(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func pkcs8DER(t *testing.T, key crypto.PrivateKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func pkcs8(t *testing.T, key crypto.PrivateKey) []byte {
+	return pemBlock("PRIVATE KEY", pkcs8DER(t, key))
+}
+
+func publicDER(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
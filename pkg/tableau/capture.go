@@ -0,0 +1,277 @@
+package tableau
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+// captureFileExtension is the extension of the files exchanges are recorded to.
+const captureFileExtension = ".json"
+
+// redactedFields are the request and response fields holding secrets. They are never written to a capture.
+var redactedFields = map[string]bool{
+	"token":                     true,
+	"password":                  true,
+	"personalAccessTokenSecret": true,
+	"jwt":                       true,
+}
+
+// exchange is a request and its response as written to a capture.
+type exchange struct {
+	Method string `json:"method"`
+	// URL is the path and query of the request, without the server.
+	URL         string          `json:"url"`
+	RequestBody json.RawMessage `json:"requestBody,omitempty"`
+	StatusCode  int             `json:"statusCode"`
+	Header      http.Header     `json:"header,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	// BodyText holds the response body when it isn't JSON, e.g. the error page of a proxy.
+	BodyText string `json:"bodyText,omitempty"`
+}
+
+// key identifies the requests the exchange is replayed for.
+func (e *exchange) key() string {
+	return e.Method + " " + e.URL
+}
+
+// capturedHeaders are the response headers kept in a capture, the others may identify the server.
+var capturedHeaders = []string{"Content-Type", "Retry-After"}
+
+// captureTransport records every exchange to a directory, one file per request, with secrets redacted.
+type captureTransport struct {
+	next       http.RoundTripper
+	dir        string
+	hashEmails bool
+
+	mtx sync.Mutex
+	seq int
+}
+
+// NewCaptureTransport returns a transport sending requests with next and recording them to dir, so the
+// sync can be replayed with NewReplayTransport. Tokens, passwords and secrets are redacted, and email
+// addresses are replaced by a hash when hashEmails is set.
+func NewCaptureTransport(next http.RoundTripper, dir string, hashEmails bool) (http.RoundTripper, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("tableau-connector: failed to create capture directory: %w", err)
+	}
+
+	return &captureTransport{next: next, dir: dir, hashEmails: hashEmails}, nil
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		requestBody = body
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	e := &exchange{
+		Method:     req.Method,
+		URL:        req.URL.RequestURI(),
+		StatusCode: resp.StatusCode,
+		Header:     http.Header{},
+	}
+	for _, name := range capturedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			e.Header.Set(name, value)
+		}
+	}
+	if len(requestBody) > 0 {
+		e.RequestBody = t.redact(requestBody)
+	}
+	if json.Valid(body) {
+		e.Body = t.redact(body)
+	} else {
+		e.BodyText = string(body)
+	}
+
+	if err := t.write(e); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// write numbers the exchanges in the order responses arrive, which is the order they are replayed in.
+func (t *captureTransport) write(e *exchange) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.seq++
+	path := filepath.Join(t.dir, fmt.Sprintf("%06d%s", t.seq, captureFileExtension))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("tableau-connector: failed to write capture: %w", err)
+	}
+
+	return nil
+}
+
+// redact returns the JSON document with secrets, and optionally email addresses, replaced.
+func (t *captureTransport) redact(data []byte) json.RawMessage {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return json.RawMessage(redactedJSON())
+	}
+
+	out, err := json.Marshal(t.redactValue("", v))
+	if err != nil {
+		return json.RawMessage(redactedJSON())
+	}
+
+	return out
+}
+
+func (t *captureTransport) redactValue(field string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = t.redactValue(k, child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = t.redactValue(field, child)
+		}
+		return v
+	case string:
+		if redactedFields[field] {
+			return redacted
+		}
+		if t.hashEmails && IsEmail(v) {
+			return hashEmail(v)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// hashEmail replaces an email address by a stable pseudonym, so users keep matching across requests.
+func hashEmail(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(sum[:6]) + "@redacted.invalid"
+}
+
+func redactedJSON() []byte {
+	data, _ := json.Marshal(redacted)
+	return data
+}
+
+// replayTransport serves the exchanges of a capture without sending requests.
+type replayTransport struct {
+	mtx       sync.Mutex
+	exchanges map[string][]*exchange
+}
+
+// NewReplayTransport returns a transport answering requests from a capture written by NewCaptureTransport.
+// Requests are matched by method, path and query. Repeated requests get the recorded responses in order,
+// and the last one once they run out. Requests that weren't captured fail.
+func NewReplayTransport(dir string) (http.RoundTripper, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+captureFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("tableau-connector: no captured requests in %s", dir)
+	}
+	sort.Strings(files)
+
+	t := &replayTransport{exchanges: make(map[string][]*exchange)}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("tableau-connector: failed to read capture: %w", err)
+		}
+
+		var e exchange
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("tableau-connector: invalid capture %s: %w", file, err)
+		}
+
+		t.exchanges[e.key()] = append(t.exchanges[e.key()], &e)
+	}
+
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	e := &exchange{Method: req.Method, URL: req.URL.RequestURI()}
+
+	t.mtx.Lock()
+	recorded := t.exchanges[e.key()]
+	if len(recorded) > 0 {
+		e = recorded[0]
+		if len(recorded) > 1 {
+			t.exchanges[e.key()] = recorded[1:]
+		}
+	}
+	t.mtx.Unlock()
+
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("tableau-connector: no captured response for %s %s", req.Method, e.URL)
+	}
+
+	body := []byte(e.BodyText)
+	if len(e.Body) > 0 {
+		body = e.Body
+	}
+
+	header := e.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,107 @@
+package tableau_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+	"github.com/conductorone/baton-tableau/pkg/tableau/tableautest"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	version := tableau.APIVersion{Major: 3, Minor: 17}
+
+	fixture := testFixture(120)
+	fixture.Sites[0].Users[1].Email = "Jane.Doe@example.com"
+	srv := tableautest.NewServer(fixture, tableautest.WithMaxPageSize(50))
+
+	baseUrl, err := tableau.APIBaseURL(srv.URL, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sync once against the server, recording the exchanges.
+	transport, err := tableau.NewCaptureTransport(srv.Client().Transport, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	session, err := tableau.NewSession(ctx, baseUrl, "", testToken, httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := session.Credentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := tableau.NewClient(session, baseUrl, version, httpClient, tableau.WithPageWorkers(4))
+	captured, err := listUsers(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sign in, 3 pages of users and sign out.
+	if len(files) != 5 {
+		t.Errorf("expected 5 captured requests, got %d", len(files))
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{credentials.Token, `"` + testToken.Secret + `"`, "Jane.Doe"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s contains %q", filepath.Base(file), secret)
+			}
+		}
+	}
+
+	// replay the sync with the server gone.
+	transport, err = tableau.NewReplayTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient = &http.Client{Transport: transport}
+
+	session, err = tableau.NewSession(ctx, baseUrl, "", &tableau.PersonalAccessToken{}, httpClient)
+	if err != nil {
+		t.Fatalf("replaying sign-in: %v", err)
+	}
+
+	client = tableau.NewClient(session, baseUrl, version, httpClient, tableau.WithPageWorkers(4))
+	replayed, err := listUsers(ctx, client)
+	if err != nil {
+		t.Fatalf("replaying users: %v", err)
+	}
+	if email := replayed[1].Email; !strings.HasSuffix(email, "@redacted.invalid") {
+		t.Errorf("expected the replayed email to be hashed, got %q", email)
+	}
+	captured[1].Email = replayed[1].Email
+	if !reflect.DeepEqual(replayed, captured) {
+		t.Errorf("replayed users differ from the captured ones")
+	}
+
+	if _, _, err := client.GetGroups(ctx, 10, 1); err == nil {
+		t.Errorf("expected requests that weren't captured to fail")
+	}
+	if err := session.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
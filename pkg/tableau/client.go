@@ -5,36 +5,117 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 
-	"github.com/conductorone/baton-sdk/pkg/uhttp"
-	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"golang.org/x/time/rate"
 )
 
 const (
 	// 1-based not zero based.
 	defaultPageNumber = 1
 	defaultPageSize   = 100
+	// allFields requests every field of a resource instead of the default subset.
+	allFields = "_all_"
 )
 
 type Client struct {
-	httpClient    *http.Client
-	authToken     string
-	siteId        string
-	baseUrl       string
-	currentUserId string
+	httpClient  *http.Client
+	session     *Session
+	siteId      string
+	contentUrl  string
+	baseUrl     string
+	apiVersion  APIVersion
+	limiter     *rate.Limiter
+	retry       retryPolicy
+	rateLimit   *rateLimitState
+	pageWorkers int
 }
 
-func NewClient(accessToken string, siteId string, baseUrl string, userId string, httpClient *http.Client) *Client {
-	return &Client{
-		httpClient:    httpClient,
-		authToken:     accessToken,
-		siteId:        siteId,
-		baseUrl:       baseUrl,
-		currentUserId: userId,
+func NewClient(session *Session, baseUrl string, apiVersion APIVersion, httpClient *http.Client, opts ...ClientOption) *Client {
+	site := session.Site()
+	c := &Client{
+		httpClient:  httpClient,
+		session:     session,
+		siteId:      site.ID,
+		contentUrl:  site.ContentURL,
+		baseUrl:     baseUrl,
+		apiVersion:  apiVersion,
+		retry:       defaultRetryPolicy(),
+		rateLimit:   &rateLimitState{},
+		pageWorkers: 1,
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// ClientOption configures optional behaviour of the Client.
+type ClientOption func(c *Client)
+
+// WithRequestsPerSecond limits the rate of requests the client sends. Zero or less means unlimited.
+func WithRequestsPerSecond(requestsPerSecond float64) ClientOption {
+	return func(c *Client) {
+		if requestsPerSecond <= 0 {
+			c.limiter = nil
+			return
+		}
+		burst := int(math.Max(1, math.Ceil(requestsPerSecond)))
+		c.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), burst)
+	}
+}
+
+// WithPageWorkers sets how many pages of a list endpoint are fetched concurrently.
+func WithPageWorkers(workers int) ClientOption {
+	return func(c *Client) {
+		if workers < 1 {
+			workers = 1
+		}
+		c.pageWorkers = workers
+	}
+}
+
+// WithMaxRetries sets how often a throttled or unavailable request is retried.
+func WithMaxRetries(maxRetries int) ClientOption {
+	return func(c *Client) {
+		c.retry.maxRetries = maxRetries
+	}
+}
+
+// APIVersion returns the REST API version the client talks to.
+func (c *Client) APIVersion() APIVersion {
+	return c.apiVersion
+}
+
+// PageWorkers returns how many pages of a list endpoint are fetched concurrently.
+func (c *Client) PageWorkers() int {
+	return c.pageWorkers
+}
+
+// SiteID returns the id of the site the client sends requests to.
+func (c *Client) SiteID() string {
+	return c.siteId
+}
+
+// SignedInUserID returns the id of the user the session is signed in to the site of the client as.
+// User ids differ between sites, so it is empty until a request was sent to the site.
+func (c *Client) SignedInUserID() string {
+	return c.session.UserID(c.siteId)
+}
+
+// ForSite returns a client for another site on the same server. It shares the session, which switches
+// between sites as requests are made, and the rate limit of c.
+func (c *Client) ForSite(site Site) API {
+	forSite := *c
+	forSite.siteId = site.ID
+	forSite.contentUrl = site.ContentURL
+
+	return &forSite
 }
 
 type Pagination struct {
@@ -50,6 +131,13 @@ type usersResponse struct {
 	} `json:"users"`
 }
 
+type groupsResponse struct {
+	Pagination Pagination `json:"pagination"`
+	Groups     struct {
+		Group []Group `json:"group"`
+	} `json:"groups"`
+}
+
 // returns query params with pagination options.
 func paginationQuery(pageSize int, pageNumber int) url.Values {
 	pageSizeString := strconv.Itoa(pageSize)
@@ -60,21 +148,18 @@ func paginationQuery(pageSize int, pageNumber int) url.Values {
 	return q
 }
 
-// Login returns credentials needed to use the API.
-func Login(ctx context.Context, baseUrl string, contentUrl string, token string, tokenName string) (Credentials, error) {
-	httpClient, err := uhttp.NewClient(ctx, uhttp.WithLogger(true, ctxzap.Extract(ctx)))
+func signIn(ctx context.Context, httpClient *http.Client, baseUrl string, contentUrl string, auth Authenticator) (Credentials, error) {
+	credentials, err := auth.SignInCredentials(ctx)
 	if err != nil {
 		return Credentials{}, err
 	}
 
+	credentials["site"] = map[string]string{
+		"contentUrl": contentUrl,
+	}
+
 	input, err := json.Marshal(map[string]interface{}{
-		"credentials": map[string]interface{}{
-			"personalAccessTokenName":   tokenName,
-			"personalAccessTokenSecret": token,
-			"site": map[string]string{
-				"contentUrl": contentUrl,
-			},
-		},
+		"credentials": credentials,
 	})
 	if err != nil {
 		return Credentials{}, err
@@ -95,6 +180,10 @@ func Login(ctx context.Context, baseUrl string, contentUrl string, token string,
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 300 {
+		return Credentials{}, newAPIError(resp)
+	}
+
 	var res struct {
 		Credentials Credentials `json:"credentials"`
 	}
@@ -105,6 +194,91 @@ func Login(ctx context.Context, baseUrl string, contentUrl string, token string,
 	return res.Credentials, nil
 }
 
+// signOut invalidates the session token.
+func signOut(ctx context.Context, httpClient *http.Client, baseUrl string, token string) error {
+	url := fmt.Sprint(baseUrl, "/auth/signout")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("X-Tableau-Auth", token)
+	req.Header.Add("accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newAPIError(resp)
+	}
+
+	return nil
+}
+
+// switchSite signs the token in to another site on the same server and returns the new credentials.
+// The previous token is no longer valid afterwards.
+func switchSite(ctx context.Context, httpClient *http.Client, baseUrl string, token string, contentUrl string) (Credentials, error) {
+	input, err := json.Marshal(map[string]interface{}{
+		"site": map[string]string{
+			"contentUrl": contentUrl,
+		},
+	})
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	url := fmt.Sprint(baseUrl, "/auth/switchSite")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(input))
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	req.Header.Add("X-Tableau-Auth", token)
+	req.Header.Add("content-type", "application/json")
+	req.Header.Add("accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return Credentials{}, newAPIError(resp)
+	}
+
+	var res struct {
+		Credentials Credentials `json:"credentials"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return Credentials{}, err
+	}
+	return res.Credentials, nil
+}
+
+// GetSites returns the sites on the server. Only server administrators can list sites.
+func (c *Client) GetSites(ctx context.Context, pageSize int, pageNumber int) ([]Site, Pagination, error) {
+	url := fmt.Sprint(c.baseUrl, "/sites")
+	q := paginationQuery(pageSize, pageNumber)
+
+	var res struct {
+		Pagination Pagination `json:"pagination"`
+		Sites      struct {
+			Site []Site `json:"site"`
+		} `json:"sites"`
+	}
+
+	if err := c.doRequest(ctx, url, &res, q, nil, http.MethodGet); err != nil {
+		return nil, Pagination{}, err
+	}
+
+	return res.Sites.Site, res.Pagination, nil
+}
+
 // GetSite returns site details of the site user is logged in to.
 func (c *Client) GetSite(ctx context.Context) (Site, error) {
 	url := fmt.Sprint(c.baseUrl, "/sites/", c.siteId)
@@ -119,10 +293,11 @@ func (c *Client) GetSite(ctx context.Context) (Site, error) {
 	return res.Site, nil
 }
 
-// GetUsers returns all users on site.
+// GetUsers returns all users on site, with all their fields.
 func (c *Client) GetUsers(ctx context.Context, pageSize int, pageNumber int) ([]User, Pagination, error) {
 	url := fmt.Sprint(c.baseUrl, "/sites/", c.siteId, "/users")
 	q := paginationQuery(pageSize, pageNumber)
+	q.Add("fields", allFields)
 
 	var res usersResponse
 	if err := c.doRequest(ctx, url, &res, q, nil, http.MethodGet); err != nil {
@@ -137,13 +312,7 @@ func (c *Client) GetGroups(ctx context.Context, pageSize int, pageNumber int) ([
 	url := fmt.Sprint(c.baseUrl, "/sites/", c.siteId, "/groups")
 	q := paginationQuery(pageSize, pageNumber)
 
-	var res struct {
-		Pagination Pagination `json:"pagination"`
-		Groups     struct {
-			Group []Group `json:"group"`
-		} `json:"groups"`
-	}
-
+	var res groupsResponse
 	if err := c.doRequest(ctx, url, &res, q, nil, http.MethodGet); err != nil {
 		return nil, Pagination{}, err
 	}
@@ -164,121 +333,31 @@ func (c *Client) GetGroupUsers(ctx context.Context, groupId string, pageSize int
 	return res.Users.User, res.Pagination, nil
 }
 
-// GetPaginatedUsers returns all users - paginated.
-func (c *Client) GetPaginatedUsers(ctx context.Context) ([]User, error) {
-	var users []User
-	pageNumber := defaultPageNumber
-	totalReturned := 0
-
-	for {
-		allUsers, paginationData, err := c.GetUsers(ctx, defaultPageSize, pageNumber)
-		if err != nil {
-			return nil, fmt.Errorf("tableau-connector: failed to list users: %w", err)
-		}
-
-		pageSizeInt, err := strconv.Atoi(paginationData.PageSize)
-		if err != nil {
-			return nil, err
-		}
-
-		totalReturned += pageSizeInt
-		totalAvailableInt, err := strconv.Atoi(paginationData.TotalAvailable)
-		if err != nil {
-			return nil, err
-		}
-
-		users = append(users, allUsers...)
-
-		if totalReturned >= totalAvailableInt {
-			break
-		}
-		pageNumber += 1
-	}
-
-	return users, nil
-}
-
-// GetPaginatedGroups returns all groups - paginated.
-func (c *Client) GetPaginatedGroups(ctx context.Context) ([]Group, error) {
-	var groups []Group
-	pageNumber := defaultPageNumber
-	totalReturned := 0
-
-	for {
-		allGroups, paginationData, err := c.GetGroups(ctx, defaultPageSize, pageNumber)
-		if err != nil {
-			return nil, fmt.Errorf("tableau-connector: failed to list groups: %w", err)
-		}
-
-		pageSizeInt, err := strconv.Atoi(paginationData.PageSize)
-		if err != nil {
-			return nil, err
-		}
-
-		totalReturned += pageSizeInt
-		totalAvailableInt, err := strconv.Atoi(paginationData.TotalAvailable)
-		if err != nil {
-			return nil, err
-		}
-
-		groups = append(groups, allGroups...)
-
-		if totalReturned >= totalAvailableInt {
-			break
-		}
-		pageNumber += 1
-	}
-
-	return groups, nil
-}
-
-// GetPaginatedGroupUsers returns all users in a group - paginated.
-func (c *Client) GetPaginatedGroupUsers(ctx context.Context, groupId string) ([]User, error) {
-	var users []User
-	pageNumber := defaultPageNumber
-	totalReturned := 0
-
-	for {
-		allUsers, paginationData, err := c.GetGroupUsers(ctx, groupId, defaultPageSize, pageNumber)
-		if err != nil {
-			return nil, fmt.Errorf("tableau-connector: failed to list group users: %w", err)
-		}
-
-		pageSizeInt, err := strconv.Atoi(paginationData.PageSize)
-		if err != nil {
-			return nil, err
-		}
-
-		totalReturned += pageSizeInt
-		totalAvailableInt, err := strconv.Atoi(paginationData.TotalAvailable)
-		if err != nil {
-			return nil, err
-		}
-
-		users = append(users, allUsers...)
+// GetUserGroups returns the groups a user is a member of. It needs UserGroupsAPIVersion.
+func (c *Client) GetUserGroups(ctx context.Context, userId string, pageSize int, pageNumber int) ([]Group, Pagination, error) {
+	url := fmt.Sprint(c.baseUrl, "/sites/", c.siteId, "/users/", userId, "/groups")
+	q := paginationQuery(pageSize, pageNumber)
 
-		if totalReturned >= totalAvailableInt {
-			break
-		}
-		pageNumber += 1
+	var res groupsResponse
+	if err := c.doRequest(ctx, url, &res, q, nil, http.MethodGet); err != nil {
+		return nil, Pagination{}, err
 	}
 
-	return users, nil
+	return res.Groups.Group, res.Pagination, nil
 }
 
 // VerifyUser returns current logged in user.
 func (c *Client) VerifyUser(ctx context.Context) error {
-	url := fmt.Sprint(c.baseUrl, "/sites/", c.siteId, "/users/", c.currentUserId)
-
-	var res struct {
-		User User `json:"user"`
-	}
+	// user ids differ between sites, so the id is read once the session is on the site of the client.
+	return c.session.WithSite(ctx, c.contentUrl, func() error {
+		url := fmt.Sprint(c.baseUrl, "/sites/", c.siteId, "/users/", c.session.User().ID)
 
-	if err := c.doRequest(ctx, url, &res, nil, nil, http.MethodGet); err != nil {
-		return err
-	}
+		var res struct {
+			User User `json:"user"`
+		}
 
-	return nil
+		return c.doSiteRequest(ctx, url, &res, nil, nil, http.MethodGet)
+	})
 }
 
 // AddUserToGroup adds user to a group.
@@ -316,28 +395,44 @@ func (c *Client) RemoveUserFromGroup(ctx context.Context, groupId, userId string
 	return nil
 }
 
+// doRequest sends the request once the session is signed in to the site of the client.
 func (c *Client) doRequest(ctx context.Context, url string, res interface{}, q url.Values, body []byte, method string) error {
-	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	return c.session.WithSite(ctx, c.contentUrl, func() error {
+		return c.doSiteRequest(ctx, url, res, q, body, method)
+	})
+}
+
+// doSiteRequest sends the request with the current session token. If the token was rejected, it signs in
+// again and retries the request once. Throttled requests are retried by send.
+func (c *Client) doSiteRequest(ctx context.Context, url string, res interface{}, q url.Values, body []byte, method string) error {
+	credentials, err := c.session.Credentials(ctx)
 	if err != nil {
 		return err
 	}
 
-	if q != nil {
-		req.URL.RawQuery = q.Encode()
-	}
-
-	req.Header.Add("X-Tableau-Auth", fmt.Sprint(c.authToken))
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(ctx, c.newRequest(ctx, url, q, body, method, credentials.Token))
 	if err != nil {
 		return err
 	}
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+
+		credentials, err = c.session.Refresh(ctx, credentials.Token)
+		if err != nil {
+			return fmt.Errorf("tableau-connector: failed to refresh session: %w", err)
+		}
+
+		resp, err = c.send(ctx, c.newRequest(ctx, url, q, body, method, credentials.Token))
+		if err != nil {
+			return err
+		}
+	}
+
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 300 {
-		return fmt.Errorf("tableau-connector: request failed with status code %d", resp.StatusCode)
+		return newAPIError(resp)
 	}
 
 	if method != http.MethodDelete {
@@ -348,3 +443,23 @@ func (c *Client) doRequest(ctx context.Context, url string, res interface{}, q u
 
 	return nil
 }
+
+// newRequest returns a function building the request, so it can be sent again on retries.
+func (c *Client) newRequest(ctx context.Context, url string, q url.Values, body []byte, method string, token string) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		if q != nil {
+			req.URL.RawQuery = q.Encode()
+		}
+
+		req.Header.Add("X-Tableau-Auth", token)
+		req.Header.Add("Accept", "application/json")
+		req.Header.Add("Content-Type", "application/json")
+
+		return req, nil
+	}
+}
@@ -0,0 +1,381 @@
+package tableau_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+	"github.com/conductorone/baton-tableau/pkg/tableau/tableautest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testToken = &tableau.PersonalAccessToken{Name: "baton", Secret: "secret"}
+
+func testFixture(users int) tableautest.Fixture {
+	site := tableautest.Site{
+		Site: tableau.Site{ID: "site-1", ContentURL: "", Name: "Default"},
+	}
+	for i := 0; i < users; i++ {
+		site.Users = append(site.Users, tableau.User{
+			ID:       fmt.Sprintf("user-%03d", i),
+			Name:     fmt.Sprintf("user%03d", i),
+			SiteRole: "Viewer",
+		})
+	}
+	site.Groups = []tableautest.Group{
+		{Group: tableau.Group{ID: "group-1", Name: "Analysts"}, Members: []string{"user-000"}},
+	}
+
+	marketing := tableautest.Site{
+		Site:  tableau.Site{ID: "site-2", ContentURL: "marketing", Name: "Marketing"},
+		Users: []tableau.User{{ID: "marketing-user", Name: "marketer", SiteRole: "Creator"}},
+	}
+
+	return tableautest.Fixture{Sites: []tableautest.Site{site, marketing}}
+}
+
+func newTestClient(t *testing.T, srv *tableautest.Server, opts ...tableau.ClientOption) *tableau.Client {
+	t.Helper()
+
+	ctx := context.Background()
+	baseUrl, err := tableau.APIBaseURL(srv.URL, tableau.APIVersion{Major: 3, Minor: 17})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := tableau.NewSession(ctx, baseUrl, "", testToken, srv.Client())
+	if err != nil {
+		t.Fatalf("signing in: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = session.Close(context.Background())
+	})
+
+	return tableau.NewClient(session, baseUrl, tableau.APIVersion{Major: 3, Minor: 17}, srv.Client(), opts...)
+}
+
+func TestSignInRejectsInvalidCredentials(t *testing.T) {
+	srv := tableautest.NewServer(testFixture(1), tableautest.WithPersonalAccessToken("baton", "other"))
+	defer srv.Close()
+
+	baseUrl, err := tableau.APIBaseURL(srv.URL, tableau.APIVersion{Major: 3, Minor: 17})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tableau.NewSession(context.Background(), baseUrl, "", testToken, srv.Client())
+
+	var apiErr *tableau.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "401001" {
+		t.Errorf("unexpected error %v", apiErr)
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected code Unauthenticated, got %v", status.Code(err))
+	}
+}
+
+func TestSignInWithUsernamePassword(t *testing.T) {
+	srv := tableautest.NewServer(testFixture(1), tableautest.WithUsernamePassword("admin", "secret"))
+	defer srv.Close()
+
+	ctx := context.Background()
+	baseUrl, err := tableau.APIBaseURL(srv.URL, tableau.APIVersion{Major: 3, Minor: 17})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &tableau.UsernamePassword{Username: "admin", Password: "secret"}
+	session, err := tableau.NewSession(ctx, baseUrl, "", auth, srv.Client())
+	if err != nil {
+		t.Fatalf("signing in: %v", err)
+	}
+	_ = session.Close(ctx)
+
+	_, err = tableau.NewSession(ctx, baseUrl, "", &tableau.UsernamePassword{Username: "admin", Password: "wrong"}, srv.Client())
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected code Unauthenticated for a wrong password, got %v", err)
+	}
+
+	auth.ImpersonateUserID = "user-000"
+	credentials, err := auth.SignInCredentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := credentials["user"].(map[string]string); !ok || user["id"] != "user-000" {
+		t.Errorf("expected the impersonated user in the credentials, got %v", credentials)
+	}
+	if got := fmt.Sprint(auth); strings.Contains(got, "secret") {
+		t.Errorf("expected the password to be redacted, got %s", got)
+	}
+}
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	srv := tableautest.NewServer(testFixture(1), tableautest.WithAPIVersion("3.19"))
+	defer srv.Close()
+
+	version, err := tableau.NegotiateAPIVersion(context.Background(), srv.URL, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != (tableau.APIVersion{Major: 3, Minor: 19}) {
+		t.Errorf("expected 3.19, got %s", version)
+	}
+}
+
+// listUsers pages through every user of the site, like the syncers of the connector do.
+func listUsers(ctx context.Context, client *tableau.Client) ([]tableau.User, error) {
+	return tableau.NewPaginator(client.GetUsers, 100, tableau.WithWorkers(client.PageWorkers())).All(ctx)
+}
+
+func TestPaginateUsers(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			// the server clamps the page size, so pages are smaller than requested.
+			srv := tableautest.NewServer(testFixture(250), tableautest.WithMaxPageSize(30))
+			defer srv.Close()
+
+			client := newTestClient(t, srv, tableau.WithPageWorkers(workers))
+			users, err := listUsers(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(users) != 250 {
+				t.Fatalf("expected 250 users, got %d", len(users))
+			}
+			for i, user := range users {
+				if want := fmt.Sprintf("user-%03d", i); user.ID != want {
+					t.Fatalf("expected %s at index %d, got %s", want, i, user.ID)
+				}
+			}
+			if got := srv.CountRequests(http.MethodGet, "sites/site-1/users"); got != 9 {
+				t.Errorf("expected 9 page requests, got %d", got)
+			}
+		})
+	}
+}
+
+func TestGetUsersRequestsAllFields(t *testing.T) {
+	fixture := testFixture(1)
+	fixture.Sites[0].Users[0].LastLogin = "2024-05-02T08:15:00Z"
+	fixture.Sites[0].Users[0].Domain = tableau.Domain{Name: "local"}
+	fixture.Sites[0].Users[0].IdpConfigurationID = "idp-okta"
+
+	srv := tableautest.NewServer(fixture)
+	defer srv.Close()
+
+	users, _, err := newTestClient(t, srv).GetUsers(context.Background(), 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 1 || users[0] != fixture.Sites[0].Users[0] {
+		t.Errorf("expected all fields of the user, got %+v", users)
+	}
+}
+
+func TestGroupMembership(t *testing.T) {
+	srv := tableautest.NewServer(testFixture(3))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := newTestClient(t, srv)
+
+	if err := client.AddUserToGroup(ctx, "group-1", "user-002"); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(ctx context.Context, pageSize int, pageNumber int) ([]tableau.User, tableau.Pagination, error) {
+		return client.GetGroupUsers(ctx, "group-1", pageSize, pageNumber)
+	}
+	members, err := tableau.NewPaginator(fetch, 100).All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[1].ID != "user-002" {
+		t.Errorf("unexpected members %v", members)
+	}
+
+	err = client.AddUserToGroup(ctx, "group-1", "user-002")
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected code AlreadyExists adding a member twice, got %v", err)
+	}
+
+	if err := client.RemoveUserFromGroup(ctx, "group-1", "user-002"); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Members("site-1", "group-1"); len(got) != 1 {
+		t.Errorf("expected one member after removal, got %v", got)
+	}
+
+	err = client.RemoveUserFromGroup(ctx, "group-1", "user-002")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected code NotFound removing a missing member, got %v", err)
+	}
+}
+
+func TestRetriesThrottledRequests(t *testing.T) {
+	srv := tableautest.NewServer(testFixture(1))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := newTestClient(t, srv, tableau.WithMaxRetries(2))
+
+	srv.Throttle(2, 0)
+	if _, err := client.GetSite(ctx); err != nil {
+		t.Fatalf("expected the request to succeed after retries: %v", err)
+	}
+	if got := client.RateLimit().Status; got != v2.RateLimitDescription_STATUS_OK {
+		t.Errorf("expected rate limit status OK, got %v", got)
+	}
+
+	srv.Throttle(3, 0)
+	_, err := client.GetSite(ctx)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected code ResourceExhausted once retries are exhausted, got %v", err)
+	}
+	if got := client.RateLimit().Status; got != v2.RateLimitDescription_STATUS_OVERLIMIT {
+		t.Errorf("expected rate limit status OVERLIMIT, got %v", got)
+	}
+}
+
+func TestRefreshesRejectedSession(t *testing.T) {
+	srv := tableautest.NewServer(testFixture(1))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := newTestClient(t, srv)
+
+	srv.ExpireSessions()
+
+	site, err := client.GetSite(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.ID != "site-1" {
+		t.Errorf("unexpected site %v", site)
+	}
+	if got := srv.CountRequests(http.MethodPost, "auth/signin"); got != 2 {
+		t.Errorf("expected to sign in again, got %d sign-ins", got)
+	}
+}
+
+func TestRefreshesExpiringSession(t *testing.T) {
+	for _, tt := range []struct {
+		timeToExpiration string
+		wantSignIns      int
+	}{
+		// sessions expiring within the refresh window are renewed before every request.
+		{timeToExpiration: "00:04:00", wantSignIns: 3},
+		{timeToExpiration: "02:00:00", wantSignIns: 1},
+	} {
+		t.Run(tt.timeToExpiration, func(t *testing.T) {
+			srv := tableautest.NewServer(testFixture(1), tableautest.WithTimeToExpiration(tt.timeToExpiration))
+			defer srv.Close()
+
+			ctx := context.Background()
+			client := newTestClient(t, srv)
+
+			for i := 0; i < 2; i++ {
+				if _, err := client.GetSite(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := srv.CountRequests(http.MethodPost, "auth/signin"); got != tt.wantSignIns {
+				t.Errorf("expected %d sign-ins, got %d", tt.wantSignIns, got)
+			}
+		})
+	}
+}
+
+func TestSessionSignsOutOnClose(t *testing.T) {
+	srv := tableautest.NewServer(testFixture(1))
+	defer srv.Close()
+
+	ctx := context.Background()
+	baseUrl, err := tableau.APIBaseURL(srv.URL, tableau.APIVersion{Major: 3, Minor: 17})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := tableau.NewSession(ctx, baseUrl, "", testToken, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Sessions() != 1 {
+		t.Fatalf("expected one session, got %d", srv.Sessions())
+	}
+
+	if err := session.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Close(ctx); err != nil {
+		t.Fatalf("closing twice: %v", err)
+	}
+	if srv.Sessions() != 0 {
+		t.Errorf("expected the session to be signed out, got %d sessions", srv.Sessions())
+	}
+}
+
+func TestClientForSite(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []tableautest.Option
+	}{
+		{name: "switch site"},
+		{name: "sign in", opts: []tableautest.Option{tableautest.WithoutSiteSwitching()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := tableautest.NewServer(testFixture(2), tt.opts...)
+			defer srv.Close()
+
+			ctx := context.Background()
+			client := newTestClient(t, srv)
+
+			sites, _, err := client.GetSites(ctx, 10, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sites) != 2 {
+				t.Fatalf("expected 2 sites, got %v", sites)
+			}
+
+			marketing := client.ForSite(sites[1])
+			for i := 0; i < 2; i++ {
+				users, _, err := marketing.GetUsers(ctx, 10, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(users) != 1 || users[0].ID != "marketing-user" {
+					t.Errorf("unexpected marketing users %v", users)
+				}
+
+				users, _, err = client.GetUsers(ctx, 10, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(users) != 2 {
+					t.Errorf("unexpected default site users %v", users)
+				}
+			}
+
+			if err := marketing.VerifyUser(ctx); err != nil {
+				t.Errorf("verifying the marketing user: %v", err)
+			}
+			if srv.Sessions() != 1 {
+				t.Errorf("expected a single session, got %d", srv.Sessions())
+			}
+		})
+	}
+}
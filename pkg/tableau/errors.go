@@ -0,0 +1,99 @@
+package tableau
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// limit how much of an error body is read.
+const maxErrorBodySize = 64 * 1024
+
+// APIError is an error returned by the Tableau REST API.
+type APIError struct {
+	StatusCode int `json:"-"`
+	// Code is the Tableau error code, e.g. 401002. Its first three digits are the HTTP status code.
+	Code    string `json:"code"`
+	Summary string `json:"summary"`
+	Detail  string `json:"detail"`
+}
+
+func (e *APIError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "tableau-connector: request failed with status code %d", e.StatusCode)
+	if e.Code != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Code)
+	}
+	if e.Summary != "" {
+		sb.WriteString(" ")
+		sb.WriteString(e.Summary)
+	}
+	if e.Detail != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Detail)
+	}
+
+	return sb.String()
+}
+
+// GRPCCode maps the HTTP status of the error to a gRPC status code.
+func (e *APIError) GRPCCode() codes.Code {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+
+	if e.StatusCode >= 500 {
+		return codes.Internal
+	}
+
+	return codes.Unknown
+}
+
+// GRPCStatus lets status.FromError and status.Code recognize the error, including when it is wrapped.
+func (e *APIError) GRPCStatus() *status.Status {
+	return status.New(e.GRPCCode(), e.Error())
+}
+
+// newAPIError reads the error envelope from an unsuccessful response.
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err == nil && len(body) > 0 {
+		var res struct {
+			Error *APIError `json:"error"`
+		}
+		if err := json.Unmarshal(body, &res); err == nil && res.Error != nil {
+			apiErr.Code = res.Error.Code
+			apiErr.Summary = res.Error.Summary
+			apiErr.Detail = res.Error.Detail
+		}
+	}
+
+	if apiErr.Summary == "" {
+		apiErr.Summary = http.StatusText(resp.StatusCode)
+	}
+
+	return apiErr
+}
@@ -1,5 +1,14 @@
 package tableau
 
+import "regexp"
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// IsEmail reports whether the value looks like an email address, such as the name of a Tableau Cloud user.
+func IsEmail(value string) bool {
+	return emailPattern.MatchString(value)
+}
+
 type Credentials struct {
 	Site                      Site   `json:"site"`
 	User                      User   `json:"user"`
@@ -11,6 +20,8 @@ type Site struct {
 	ID         string `json:"id"`
 	ContentURL string `json:"contentUrl"`
 	Name       string `json:"name"`
+	// State is Active or Suspended. Only set when listing sites.
+	State string `json:"state,omitempty"`
 }
 
 type User struct {
@@ -19,6 +30,20 @@ type User struct {
 	FullName string `json:"fullName"`
 	Name     string `json:"name"`
 	SiteRole string `json:"siteRole"`
+	// The fields below are only returned when all fields are requested, see GetUsers.
+	// LastLogin is an ISO 8601 timestamp, it is empty for users who never signed in.
+	LastLogin          string `json:"lastLogin,omitempty"`
+	AuthSetting        string `json:"authSetting,omitempty"`
+	Locale             string `json:"locale,omitempty"`
+	Language           string `json:"language,omitempty"`
+	Domain             Domain `json:"domain"`
+	ExternalAuthUserID string `json:"externalAuthUserId,omitempty"`
+	IdpConfigurationID string `json:"idpConfigurationId,omitempty"`
+}
+
+// Domain is the Active Directory domain of a user, "local" for users managed by Tableau.
+type Domain struct {
+	Name string `json:"name,omitempty"`
 }
 
 type Group struct {
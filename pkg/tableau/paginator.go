@@ -0,0 +1,256 @@
+package tableau
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// PageFunc fetches a single page of a list endpoint. Page numbers are 1-based.
+type PageFunc[T any] func(ctx context.Context, pageSize int, pageNumber int) ([]T, Pagination, error)
+
+// PaginatorOption configures optional behaviour of a Paginator.
+type PaginatorOption func(o *paginatorOptions)
+
+type paginatorOptions struct {
+	workers  int
+	maxPages int
+}
+
+// WithWorkers prefetches up to workers pages concurrently once the total is known from the first page.
+// Pages are still returned in order.
+func WithWorkers(workers int) PaginatorOption {
+	return func(o *paginatorOptions) {
+		o.workers = workers
+	}
+}
+
+// WithMaxPages stops the paginator after it read the given number of pages. Zero means no limit.
+func WithMaxPages(maxPages int) PaginatorOption {
+	return func(o *paginatorOptions) {
+		o.maxPages = maxPages
+	}
+}
+
+type pageResult[T any] struct {
+	items      []T
+	pagination Pagination
+	err        error
+}
+
+// Paginator walks the pages of a list endpoint:
+//
+//	p := NewPaginator(client.GetUsers, 100)
+//	for p.Next(ctx) {
+//		users := p.Page()
+//	}
+//	if err := p.Err(); err != nil {
+//		...
+//	}
+//
+// It counts items from the page number and page size reported by the server rather than the requested
+// page size, so it works when the server clamps the page size, including when it starts at a later page.
+// It stops on an empty page.
+type Paginator[T any] struct {
+	fetch      PageFunc[T]
+	opts       paginatorOptions
+	pageSize   int
+	pageNumber int
+	pagesRead  int
+	// fetched is the number of items up to and including the last page read.
+	fetched int
+	// servedPageSize is the page size reported by the server, 0 until a page reported one.
+	servedPageSize int
+	total          int
+	done           bool
+	page           []T
+	prefetched     []pageResult[T]
+	err            error
+}
+
+// NewPaginator returns a paginator starting at the first page.
+func NewPaginator[T any](fetch PageFunc[T], pageSize int, opts ...PaginatorOption) *Paginator[T] {
+	return NewPaginatorAt(fetch, pageSize, defaultPageNumber, opts...)
+}
+
+// NewPaginatorAt returns a paginator starting at the given page.
+func NewPaginatorAt[T any](fetch PageFunc[T], pageSize int, pageNumber int, opts ...PaginatorOption) *Paginator[T] {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageNumber < defaultPageNumber {
+		pageNumber = defaultPageNumber
+	}
+
+	p := &Paginator[T]{
+		fetch:      fetch,
+		opts:       paginatorOptions{workers: 1},
+		pageSize:   pageSize,
+		pageNumber: pageNumber,
+		fetched:    (pageNumber - defaultPageNumber) * pageSize,
+		total:      -1,
+	}
+
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+
+	return p
+}
+
+// Next fetches the next page. It returns false once all pages were read or an error occurred.
+func (p *Paginator[T]) Next(ctx context.Context) bool {
+	if p.done || p.err != nil {
+		return false
+	}
+
+	if p.opts.maxPages > 0 && p.pagesRead >= p.opts.maxPages {
+		return false
+	}
+
+	if len(p.prefetched) == 0 {
+		p.prefetch(ctx)
+	}
+
+	res := p.prefetched[0]
+	p.prefetched = p.prefetched[1:]
+
+	if res.err != nil {
+		p.err = res.err
+		p.prefetched = nil
+		return false
+	}
+
+	total, err := res.pagination.Total()
+	if err != nil {
+		p.err = err
+		p.prefetched = nil
+		return false
+	}
+
+	p.page = res.items
+	p.total = total
+	p.advance(res.pagination, len(res.items))
+	p.pageNumber++
+	p.pagesRead++
+
+	switch {
+	case len(res.items) == 0:
+		p.done = true
+		return false
+	case total >= 0:
+		p.done = p.fetched >= total
+	default:
+		// without a total, a short page is the last one.
+		p.done = len(res.items) < p.effectivePageSize()
+	}
+
+	if p.done {
+		p.prefetched = nil
+	}
+
+	return true
+}
+
+// prefetch fetches the next page, or a window of pages concurrently once the total is known.
+func (p *Paginator[T]) prefetch(ctx context.Context) {
+	window := 1
+	if p.opts.workers > 1 && p.total >= 0 {
+		pageSize := p.effectivePageSize()
+		remaining := (p.total - p.fetched + pageSize - 1) / pageSize
+		window = p.opts.workers
+		if remaining < window {
+			window = remaining
+		}
+		if p.opts.maxPages > 0 && p.opts.maxPages-p.pagesRead < window {
+			window = p.opts.maxPages - p.pagesRead
+		}
+		if window < 1 {
+			window = 1
+		}
+	}
+
+	results := make([]pageResult[T], window)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			items, pagination, err := p.fetch(ctx, p.pageSize, p.pageNumber+i)
+			results[i] = pageResult[T]{items: items, pagination: pagination, err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	p.prefetched = results
+}
+
+// advance records a page of count items. Pages before it may have been read by another paginator, so
+// the offset is taken from the pagination of the page when the server reported it.
+func (p *Paginator[T]) advance(pagination Pagination, count int) {
+	pageNumber, numberErr := strconv.Atoi(pagination.PageNumber)
+	pageSize, sizeErr := strconv.Atoi(pagination.PageSize)
+	if numberErr != nil || sizeErr != nil || pageNumber < 1 || pageSize < 1 {
+		p.fetched += count
+		return
+	}
+
+	p.servedPageSize = pageSize
+	p.fetched = (pageNumber-1)*pageSize + count
+}
+
+// effectivePageSize returns the page size the server serves, the requested one until it is known.
+func (p *Paginator[T]) effectivePageSize() int {
+	if p.servedPageSize > 0 {
+		return p.servedPageSize
+	}
+	return p.pageSize
+}
+
+// Page returns the items of the page read by the last call to Next.
+func (p *Paginator[T]) Page() []T {
+	return p.page
+}
+
+// NextPageNumber returns the page Next will fetch, or 0 when there are no pages left.
+func (p *Paginator[T]) NextPageNumber() int {
+	if p.done {
+		return 0
+	}
+	return p.pageNumber
+}
+
+// Total returns the number of items reported by the server, or -1 before the first page is read.
+func (p *Paginator[T]) Total() int {
+	return p.total
+}
+
+// Err returns the error that stopped the paginator, if any.
+func (p *Paginator[T]) Err() error {
+	return p.err
+}
+
+// All reads every remaining page and returns the items.
+func (p *Paginator[T]) All(ctx context.Context) ([]T, error) {
+	var rv []T
+	for p.Next(ctx) {
+		rv = append(rv, p.Page()...)
+	}
+
+	return rv, p.Err()
+}
+
+// Total returns the totalAvailable count, or -1 if the response didn't include one.
+func (p Pagination) Total() (int, error) {
+	if p.TotalAvailable == "" {
+		return -1, nil
+	}
+
+	total, err := strconv.Atoi(p.TotalAvailable)
+	if err != nil {
+		return 0, fmt.Errorf("tableau-connector: invalid totalAvailable %q: %w", p.TotalAvailable, err)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,188 @@
+package tableau
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultMaxRetries     = 5
+	defaultRetryBaseDelay = time.Second
+	defaultRetryMaxDelay  = time.Minute
+)
+
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		maxRetries: defaultMaxRetries,
+		baseDelay:  defaultRetryBaseDelay,
+		maxDelay:   defaultRetryMaxDelay,
+	}
+}
+
+// delay returns the wait before the given retry attempt, starting at 0. Retry-After takes precedence
+// over the jittered exponential backoff.
+func (p retryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if retryAfter, ok := parseRetryAfter(resp); ok {
+		if retryAfter > p.maxDelay {
+			return p.maxDelay
+		}
+		return retryAfter
+	}
+
+	backoff := p.baseDelay << attempt
+	if backoff <= 0 || backoff > p.maxDelay {
+		backoff = p.maxDelay
+	}
+
+	// full jitter, so concurrent callers don't retry in lockstep.
+	return time.Duration(rand.Int63n(int64(backoff)) + 1) //nolint:gosec // jitter doesn't need a secure random source.
+}
+
+func isRetryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter reads the Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		wait := time.Until(at)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// rateLimitState tracks the rate limit status reported to the Baton syncer.
+type rateLimitState struct {
+	mtx       sync.Mutex
+	overLimit bool
+	resetAt   time.Time
+}
+
+func (s *rateLimitState) throttled(resetAt time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.overLimit = true
+	if resetAt.After(s.resetAt) {
+		s.resetAt = resetAt
+	}
+}
+
+func (s *rateLimitState) succeeded() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.overLimit = false
+}
+
+// RateLimit describes the current rate limit status of the client.
+func (c *Client) RateLimit() *v2.RateLimitDescription {
+	c.rateLimit.mtx.Lock()
+	defer c.rateLimit.mtx.Unlock()
+
+	desc := &v2.RateLimitDescription{
+		Status: v2.RateLimitDescription_STATUS_OK,
+	}
+
+	if c.limiter != nil {
+		desc.Limit = int64(c.limiter.Burst())
+		desc.Remaining = int64(math.Max(0, c.limiter.Tokens()))
+	}
+
+	if c.rateLimit.overLimit {
+		desc.Status = v2.RateLimitDescription_STATUS_OVERLIMIT
+		desc.Remaining = 0
+	}
+
+	if !c.rateLimit.resetAt.IsZero() && c.rateLimit.resetAt.After(time.Now()) {
+		desc.ResetAt = timestamppb.New(c.rateLimit.resetAt)
+	}
+
+	return desc
+}
+
+// send waits for the request budget and sends the request, retrying while Tableau is throttling
+// or unavailable.
+func (c *Client) send(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	l := ctxzap.Extract(ctx)
+
+	for attempt := 0; ; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isRetryable(resp.StatusCode) {
+			c.rateLimit.succeeded()
+			return resp, nil
+		}
+
+		wait := c.retry.delay(attempt, resp)
+		c.rateLimit.throttled(time.Now().Add(wait))
+
+		if attempt >= c.retry.maxRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		l.Debug(
+			"tableau-connector: request throttled, retrying",
+			zap.String("url", req.URL.Path),
+			zap.Int("status_code", resp.StatusCode),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("wait", wait),
+		)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package tableau
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// serverinfo is available without signing in from REST API 2.4 onwards.
+var serverInfoAPIVersion = APIVersion{Major: 2, Minor: 4}
+
+// UserGroupsAPIVersion is the first REST API version listing the groups of a user, see GetUserGroups.
+var UserGroupsAPIVersion = APIVersion{Major: 3, Minor: 7}
+
+// APIVersion is a Tableau REST API version such as 3.17.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+// ParseAPIVersion parses a version in the major.minor format.
+func ParseAPIVersion(value string) (APIVersion, error) {
+	major, minor, ok := strings.Cut(strings.TrimSpace(value), ".")
+	if !ok {
+		return APIVersion{}, fmt.Errorf("tableau-connector: invalid api version %q", value)
+	}
+
+	majorInt, err := strconv.Atoi(major)
+	if err != nil {
+		return APIVersion{}, fmt.Errorf("tableau-connector: invalid api version %q: %w", value, err)
+	}
+
+	minorInt, err := strconv.Atoi(minor)
+	if err != nil {
+		return APIVersion{}, fmt.Errorf("tableau-connector: invalid api version %q: %w", value, err)
+	}
+
+	return APIVersion{Major: majorInt, Minor: minorInt}, nil
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// AtLeast reports whether v is the same or a newer version than other.
+func (v APIVersion) AtLeast(other APIVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	return v.Minor >= other.Minor
+}
+
+type ProductVersion struct {
+	Value string `json:"value"`
+	Build string `json:"build"`
+}
+
+type ServerInfo struct {
+	ProductVersion ProductVersion `json:"productVersion"`
+	RestAPIVersion string         `json:"restApiVersion"`
+}
+
+// APIBaseURL returns the base url of the REST API for the given version.
+func APIBaseURL(serverUrl string, version APIVersion) (string, error) {
+	return url.JoinPath(serverUrl, "api", version.String())
+}
+
+// GetServerInfo returns the product version and the highest REST API version supported by the server.
+func GetServerInfo(ctx context.Context, serverUrl string, httpClient *http.Client) (ServerInfo, error) {
+	baseUrl, err := APIBaseURL(serverUrl, serverInfoAPIVersion)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprint(baseUrl, "/serverinfo"), nil)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+
+	req.Header.Add("accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return ServerInfo{}, newAPIError(resp)
+	}
+
+	var res struct {
+		ServerInfo ServerInfo `json:"serverInfo"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return ServerInfo{}, err
+	}
+
+	return res.ServerInfo, nil
+}
+
+// NegotiateAPIVersion returns the highest REST API version supported by the server.
+func NegotiateAPIVersion(ctx context.Context, serverUrl string, httpClient *http.Client) (APIVersion, error) {
+	info, err := GetServerInfo(ctx, serverUrl, httpClient)
+	if err != nil {
+		return APIVersion{}, err
+	}
+
+	return ParseAPIVersion(info.RestAPIVersion)
+}
@@ -0,0 +1,310 @@
+package tableau
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	// sign in again when the session is this close to expiring.
+	sessionRefreshWindow = 5 * time.Minute
+	signOutTimeout       = 10 * time.Second
+)
+
+var errSessionClosed = errors.New("tableau-connector: session is signed out")
+
+// Session owns the lifecycle of a Tableau server session. It signs in, renews the credentials when
+// they expire and signs out when closed or when the context it was created with is cancelled.
+// A session is signed in to one site at a time and switches between sites on demand, see WithSite.
+// It is safe for concurrent use.
+type Session struct {
+	// siteMtx is held for reading while requests are sent to the current site, and for writing to switch sites.
+	siteMtx     sync.RWMutex
+	mtx         sync.RWMutex
+	httpClient  *http.Client
+	baseUrl     string
+	contentUrl  string
+	auth        Authenticator
+	credentials Credentials
+	expiresAt   time.Time
+	closed      bool
+	done        chan struct{}
+	// siteUsers maps site ids to the id of the session's user on that site.
+	siteUsers map[string]string
+}
+
+// NewSession signs in and returns a session for the given site. The session is signed out once ctx is done.
+func NewSession(ctx context.Context, baseUrl string, contentUrl string, auth Authenticator, httpClient *http.Client) (*Session, error) {
+	s := &Session{
+		httpClient: httpClient,
+		baseUrl:    baseUrl,
+		contentUrl: contentUrl,
+		auth:       auth,
+		siteUsers:  make(map[string]string),
+		done:       make(chan struct{}),
+	}
+
+	if err := s.signIn(ctx); err != nil {
+		return nil, err
+	}
+
+	go s.closeOnDone(ctx)
+
+	return s, nil
+}
+
+// Site returns the site the session is signed in to.
+func (s *Session) Site() Site {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.credentials.Site
+}
+
+// User returns the user the session is signed in as. Only the ID is populated.
+func (s *Session) User() User {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.credentials.User
+}
+
+// UserID returns the id of the user the session signed in to the site as, or "" if it never signed in to it.
+func (s *Session) UserID(siteId string) string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.siteUsers[siteId]
+}
+
+// WithSite calls fn once the session is signed in to the site with the given content url, switching sites
+// first if needed. Requests for other sites wait until fn returns, so fn must not call WithSite itself.
+func (s *Session) WithSite(ctx context.Context, contentUrl string, fn func() error) error {
+	s.siteMtx.RLock()
+	if s.currentContentURL() == contentUrl {
+		defer s.siteMtx.RUnlock()
+		return fn()
+	}
+	s.siteMtx.RUnlock()
+
+	// fn runs before the lock is released, so callers switching back and forth still make progress.
+	s.siteMtx.Lock()
+	defer s.siteMtx.Unlock()
+
+	if err := s.switchSite(ctx, contentUrl); err != nil {
+		return err
+	}
+
+	return fn()
+}
+
+func (s *Session) currentContentURL() string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.contentUrl
+}
+
+// switchSite moves the session to another site, siteMtx must be held. Tableau Cloud doesn't support
+// switching sites, so when the switch is rejected the session signs out and signs in to the other site instead.
+func (s *Session) switchSite(ctx context.Context, contentUrl string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return errSessionClosed
+	}
+
+	if s.contentUrl == contentUrl {
+		return nil
+	}
+
+	l := ctxzap.Extract(ctx)
+	l.Debug("tableau-connector: switching site", zap.String("from", s.contentUrl), zap.String("to", contentUrl))
+
+	token := s.credentials.Token
+	credentials, err := switchSite(ctx, s.httpClient, s.baseUrl, token, contentUrl)
+	if err == nil && credentials.Token == "" {
+		return fmt.Errorf("tableau-connector: switching to site %q returned no token", contentUrl)
+	}
+	if err == nil {
+		s.contentUrl = contentUrl
+		s.setCredentialsLocked(ctx, credentials)
+		return nil
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("tableau-connector: failed to switch to site %q: %w", contentUrl, err)
+	}
+
+	l.Debug("tableau-connector: unable to switch site, signing in instead", zap.Error(err))
+
+	if err := signOut(ctx, s.httpClient, s.baseUrl, token); err != nil {
+		l.Debug("tableau-connector: failed to sign out before switching site", zap.Error(err))
+	}
+
+	s.contentUrl = contentUrl
+	if err := s.signInLocked(ctx); err != nil {
+		return fmt.Errorf("tableau-connector: failed to sign in to site %q: %w", contentUrl, err)
+	}
+
+	return nil
+}
+
+// Credentials returns valid credentials, signing in again if the current ones are about to expire.
+func (s *Session) Credentials(ctx context.Context) (Credentials, error) {
+	s.mtx.RLock()
+	credentials, expiresAt, closed := s.credentials, s.expiresAt, s.closed
+	s.mtx.RUnlock()
+
+	if closed {
+		return Credentials{}, errSessionClosed
+	}
+
+	if expiresAt.IsZero() || time.Until(expiresAt) > sessionRefreshWindow {
+		return credentials, nil
+	}
+
+	return s.Refresh(ctx, credentials.Token)
+}
+
+// Refresh signs in again unless another caller already replaced the stale token.
+func (s *Session) Refresh(ctx context.Context, staleToken string) (Credentials, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return Credentials{}, errSessionClosed
+	}
+
+	if s.credentials.Token != staleToken {
+		return s.credentials, nil
+	}
+
+	ctxzap.Extract(ctx).Debug("tableau-connector: refreshing session", zap.Time("expires_at", s.expiresAt))
+
+	if err := s.signInLocked(ctx); err != nil {
+		return Credentials{}, err
+	}
+
+	return s.credentials, nil
+}
+
+// Close signs out of the session. It is safe to call more than once.
+func (s *Session) Close(ctx context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	close(s.done)
+
+	token := s.credentials.Token
+	s.credentials = Credentials{}
+	if token == "" {
+		return nil
+	}
+
+	if err := signOut(ctx, s.httpClient, s.baseUrl, token); err != nil {
+		return fmt.Errorf("tableau-connector: failed to sign out: %w", err)
+	}
+
+	return nil
+}
+
+// closeOnDone signs out once ctx is cancelled, unless the session was closed first.
+func (s *Session) closeOnDone(ctx context.Context) {
+	select {
+	case <-s.done:
+		return
+	case <-ctx.Done():
+	}
+
+	l := ctxzap.Extract(ctx)
+	signOutCtx, cancel := context.WithTimeout(ctxzap.ToContext(context.Background(), l), signOutTimeout)
+	defer cancel()
+
+	if err := s.Close(signOutCtx); err != nil {
+		l.Warn("tableau-connector: failed to sign out after context cancellation", zap.Error(err))
+	}
+}
+
+func (s *Session) signIn(ctx context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.signInLocked(ctx)
+}
+
+func (s *Session) signInLocked(ctx context.Context) error {
+	credentials, err := signIn(ctx, s.httpClient, s.baseUrl, s.contentUrl, s.auth)
+	if err != nil {
+		return err
+	}
+
+	if credentials.Token == "" {
+		return fmt.Errorf("tableau-connector: sign-in returned no token")
+	}
+
+	s.setCredentialsLocked(ctx, credentials)
+
+	return nil
+}
+
+func (s *Session) setCredentialsLocked(ctx context.Context, credentials Credentials) {
+	s.credentials = credentials
+	s.siteUsers[credentials.Site.ID] = credentials.User.ID
+	// use the content url as Tableau spells it, so clients of the site match it.
+	s.contentUrl = credentials.Site.ContentURL
+	s.expiresAt = time.Time{}
+
+	ttl, err := parseTimeToExpiration(credentials.EstimatedTimeToExpiration)
+	if err != nil {
+		ctxzap.Extract(ctx).Warn(
+			"tableau-connector: unable to parse session expiration",
+			zap.String("estimated_time_to_expiration", credentials.EstimatedTimeToExpiration),
+			zap.Error(err),
+		)
+		return
+	}
+	if ttl > 0 {
+		s.expiresAt = time.Now().Add(ttl)
+	}
+}
+
+// parseTimeToExpiration parses durations in the hh:mm:ss format used by Tableau, where hours may exceed 24.
+func parseTimeToExpiration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("unexpected format %q", value)
+	}
+
+	var total time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n) * units[i]
+	}
+
+	return total, nil
+}
@@ -0,0 +1,133 @@
+package tableautest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+)
+
+// Fixture is the data served by a Server.
+type Fixture struct {
+	Sites []Site `json:"sites"`
+}
+
+// Site is a site with its users and groups.
+type Site struct {
+	tableau.Site
+	// SignInUserID is the user sessions on the site are signed in as. Defaults to the first user.
+	SignInUserID string         `json:"signInUserId,omitempty"`
+	Users        []tableau.User `json:"users"`
+	Groups       []Group        `json:"groups"`
+}
+
+// Group is a group with the ids of its members.
+type Group struct {
+	tableau.Group
+	Members []string `json:"members"`
+}
+
+// LoadFixture reads a fixture from a JSON file.
+func LoadFixture(path string) (Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Fixture{}, err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return Fixture{}, fmt.Errorf("tableautest: invalid fixture %s: %w", path, err)
+	}
+
+	return fixture, nil
+}
+
+// copySites returns a copy of the sites, so changes to membership don't modify the fixture.
+func (f Fixture) copySites() []*Site {
+	sites := make([]*Site, 0, len(f.Sites))
+	for _, site := range f.Sites {
+		site := site
+		site.Users = append([]tableau.User(nil), site.Users...)
+		groups := site.Groups
+		site.Groups = make([]Group, 0, len(groups))
+		for _, group := range groups {
+			group.Members = append([]string(nil), group.Members...)
+			site.Groups = append(site.Groups, group)
+		}
+		sites = append(sites, &site)
+	}
+	return sites
+}
+
+// signInUserID returns the id of the user sessions on the site are signed in as.
+func (s *Site) signInUserID() string {
+	if s.SignInUserID == "" && len(s.Users) > 0 {
+		return s.Users[0].ID
+	}
+	return s.SignInUserID
+}
+
+func findSite(sites []*Site, id string) *Site {
+	for _, site := range sites {
+		if site.ID == id {
+			return site
+		}
+	}
+	return nil
+}
+
+func findUser(site *Site, id string) *tableau.User {
+	for i := range site.Users {
+		if site.Users[i].ID == id {
+			return &site.Users[i]
+		}
+	}
+	return nil
+}
+
+func findGroup(site *Site, id string) *Group {
+	for i := range site.Groups {
+		if site.Groups[i].ID == id {
+			return &site.Groups[i]
+		}
+	}
+	return nil
+}
+
+// userGroups returns the groups the user is a member of, in the order of the site's groups.
+func userGroups(site *Site, userID string) []tableau.Group {
+	var rv []tableau.Group
+	for _, group := range site.Groups {
+		for _, member := range group.Members {
+			if member == userID {
+				rv = append(rv, group.Group)
+				break
+			}
+		}
+	}
+	return rv
+}
+
+// page returns a page of items and its pagination, like the list endpoints of Tableau.
+func page[T any](items []T, pageSize int, pageNumber int) ([]T, tableau.Pagination) {
+	start := (pageNumber - 1) * pageSize
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + pageSize
+	if end > len(items) {
+		end = len(items)
+	}
+
+	return items[start:end], tableau.Pagination{
+		PageNumber:     strconv.Itoa(pageNumber),
+		PageSize:       strconv.Itoa(pageSize),
+		TotalAvailable: strconv.Itoa(len(items)),
+	}
+}
+
+func newAPIError(status int, code string, summary string, detail string) *tableau.APIError {
+	return &tableau.APIError{StatusCode: status, Code: code, Summary: summary, Detail: detail}
+}
@@ -0,0 +1,300 @@
+package tableautest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+)
+
+// Memory implements tableau.API in memory, serving a Fixture without HTTP. It is bound to the first site
+// of the fixture, ForSite returns a Memory bound to another site sharing the same data.
+// Errors can be injected per method with Fail.
+type Memory struct {
+	state  *memoryState
+	siteID string
+}
+
+type memoryState struct {
+	mtx       sync.Mutex
+	opts      options
+	sites     []*Site
+	faults    map[string][]error
+	calls     map[string]int
+	rateLimit *v2.RateLimitDescription
+}
+
+var _ tableau.API = (*Memory)(nil)
+
+// NewMemory returns an API serving the fixture, bound to its first site.
+func NewMemory(fixture Fixture, opts ...Option) *Memory {
+	state := &memoryState{
+		opts:   newOptions(opts),
+		sites:  fixture.copySites(),
+		faults: make(map[string][]error),
+		calls:  make(map[string]int),
+		rateLimit: &v2.RateLimitDescription{
+			Status: v2.RateLimitDescription_STATUS_OK,
+		},
+	}
+
+	m := &Memory{state: state}
+	if len(state.sites) > 0 {
+		m.siteID = state.sites[0].ID
+	}
+
+	return m
+}
+
+// Fail makes the next calls of the method return the given errors, one call per error. The method is the
+// name of a tableau.API method, e.g. "GetUsers". Faults are shared by every site.
+func (m *Memory) Fail(method string, errs ...error) {
+	m.state.mtx.Lock()
+	defer m.state.mtx.Unlock()
+
+	m.state.faults[method] = append(m.state.faults[method], errs...)
+}
+
+// Calls returns how often the method was called, including calls that failed.
+func (m *Memory) Calls(method string) int {
+	m.state.mtx.Lock()
+	defer m.state.mtx.Unlock()
+
+	return m.state.calls[method]
+}
+
+// SetRateLimit sets the description returned by RateLimit.
+func (m *Memory) SetRateLimit(desc *v2.RateLimitDescription) {
+	m.state.mtx.Lock()
+	defer m.state.mtx.Unlock()
+
+	m.state.rateLimit = desc
+}
+
+// Members returns the ids of the members of a group.
+func (m *Memory) Members(siteID string, groupID string) []string {
+	m.state.mtx.Lock()
+	defer m.state.mtx.Unlock()
+
+	site := findSite(m.state.sites, siteID)
+	if site == nil {
+		return nil
+	}
+	group := findGroup(site, groupID)
+	if group == nil {
+		return nil
+	}
+
+	return append([]string(nil), group.Members...)
+}
+
+func (m *Memory) SiteID() string {
+	return m.siteID
+}
+
+func (m *Memory) ForSite(site tableau.Site) tableau.API {
+	return &Memory{state: m.state, siteID: site.ID}
+}
+
+func (m *Memory) SignedInUserID() string {
+	m.state.mtx.Lock()
+	defer m.state.mtx.Unlock()
+
+	site := findSite(m.state.sites, m.siteID)
+	if site == nil {
+		return ""
+	}
+	return site.signInUserID()
+}
+
+func (m *Memory) PageWorkers() int {
+	return m.state.opts.pageWorkers
+}
+
+func (m *Memory) RateLimit() *v2.RateLimitDescription {
+	m.state.mtx.Lock()
+	defer m.state.mtx.Unlock()
+
+	return m.state.rateLimit
+}
+
+func (m *Memory) VerifyUser(ctx context.Context) error {
+	return m.call("VerifyUser", func(site *Site) error {
+		userID := site.signInUserID()
+		if findUser(site, userID) == nil {
+			return userNotFound(userID)
+		}
+		return nil
+	})
+}
+
+func (m *Memory) GetSite(ctx context.Context) (tableau.Site, error) {
+	var rv tableau.Site
+	err := m.call("GetSite", func(site *Site) error {
+		rv = site.Site
+		return nil
+	})
+	return rv, err
+}
+
+func (m *Memory) GetSites(ctx context.Context, pageSize int, pageNumber int) ([]tableau.Site, tableau.Pagination, error) {
+	var rv []tableau.Site
+	var pagination tableau.Pagination
+	err := m.call("GetSites", func(_ *Site) error {
+		sites := make([]tableau.Site, 0, len(m.state.sites))
+		for _, site := range m.state.sites {
+			sites = append(sites, site.Site)
+		}
+		var err error
+		rv, pagination, err = memoryPage(m.state.opts, sites, pageSize, pageNumber)
+		return err
+	})
+	return rv, pagination, err
+}
+
+func (m *Memory) GetUsers(ctx context.Context, pageSize int, pageNumber int) ([]tableau.User, tableau.Pagination, error) {
+	var rv []tableau.User
+	var pagination tableau.Pagination
+	err := m.call("GetUsers", func(site *Site) error {
+		var err error
+		rv, pagination, err = memoryPage(m.state.opts, site.Users, pageSize, pageNumber)
+		return err
+	})
+	return rv, pagination, err
+}
+
+func (m *Memory) GetGroups(ctx context.Context, pageSize int, pageNumber int) ([]tableau.Group, tableau.Pagination, error) {
+	var rv []tableau.Group
+	var pagination tableau.Pagination
+	err := m.call("GetGroups", func(site *Site) error {
+		groups := make([]tableau.Group, 0, len(site.Groups))
+		for _, group := range site.Groups {
+			groups = append(groups, group.Group)
+		}
+		var err error
+		rv, pagination, err = memoryPage(m.state.opts, groups, pageSize, pageNumber)
+		return err
+	})
+	return rv, pagination, err
+}
+
+func (m *Memory) GetGroupUsers(ctx context.Context, groupId string, pageSize int, pageNumber int) ([]tableau.User, tableau.Pagination, error) {
+	var rv []tableau.User
+	var pagination tableau.Pagination
+	err := m.call("GetGroupUsers", func(site *Site) error {
+		group := findGroup(site, groupId)
+		if group == nil {
+			return groupNotFound(groupId)
+		}
+		members := make([]tableau.User, 0, len(group.Members))
+		for _, id := range group.Members {
+			if user := findUser(site, id); user != nil {
+				members = append(members, *user)
+			}
+		}
+		var err error
+		rv, pagination, err = memoryPage(m.state.opts, members, pageSize, pageNumber)
+		return err
+	})
+	return rv, pagination, err
+}
+
+func (m *Memory) GetUserGroups(ctx context.Context, userId string, pageSize int, pageNumber int) ([]tableau.Group, tableau.Pagination, error) {
+	var rv []tableau.Group
+	var pagination tableau.Pagination
+	err := m.call("GetUserGroups", func(site *Site) error {
+		if findUser(site, userId) == nil {
+			return userNotFound(userId)
+		}
+		var err error
+		rv, pagination, err = memoryPage(m.state.opts, userGroups(site, userId), pageSize, pageNumber)
+		return err
+	})
+	return rv, pagination, err
+}
+
+func (m *Memory) AddUserToGroup(ctx context.Context, groupId string, userId string) error {
+	return m.call("AddUserToGroup", func(site *Site) error {
+		group := findGroup(site, groupId)
+		if group == nil {
+			return groupNotFound(groupId)
+		}
+		if findUser(site, userId) == nil {
+			return userNotFound(userId)
+		}
+		for _, id := range group.Members {
+			if id == userId {
+				return newAPIError(http.StatusConflict, "409011", "Specified user is already a member of the group",
+					fmt.Sprintf("User '%s' is already a member of group '%s'.", userId, groupId))
+			}
+		}
+		group.Members = append(group.Members, userId)
+		return nil
+	})
+}
+
+func (m *Memory) RemoveUserFromGroup(ctx context.Context, groupId string, userId string) error {
+	return m.call("RemoveUserFromGroup", func(site *Site) error {
+		group := findGroup(site, groupId)
+		if group == nil {
+			return groupNotFound(groupId)
+		}
+		for i, id := range group.Members {
+			if id == userId {
+				group.Members = append(group.Members[:i], group.Members[i+1:]...)
+				return nil
+			}
+		}
+		return newAPIError(http.StatusNotFound, "404002", "User not found",
+			fmt.Sprintf("User '%s' is not a member of group '%s'.", userId, groupId))
+	})
+}
+
+// call records the call and returns the next injected fault of the method, or runs fn with the site
+// the Memory is bound to.
+func (m *Memory) call(method string, fn func(site *Site) error) error {
+	m.state.mtx.Lock()
+	defer m.state.mtx.Unlock()
+
+	m.state.calls[method]++
+
+	if faults := m.state.faults[method]; len(faults) > 0 {
+		m.state.faults[method] = faults[1:]
+		return faults[0]
+	}
+
+	site := findSite(m.state.sites, m.siteID)
+	if site == nil {
+		return newAPIError(http.StatusNotFound, "404000", "Site not found", fmt.Sprintf("Site '%s' could not be found.", m.siteID))
+	}
+
+	return fn(site)
+}
+
+// memoryPage returns a copy of the page, so callers don't observe later changes to membership. Page sizes
+// are clamped like the server does.
+func memoryPage[T any](opts options, items []T, pageSize int, pageNumber int) ([]T, tableau.Pagination, error) {
+	if pageSize < 1 {
+		return nil, tableau.Pagination{}, newAPIError(http.StatusBadRequest, "400007", "Bad Request", "The page size must be a positive integer.")
+	}
+	if pageNumber < 1 {
+		return nil, tableau.Pagination{}, newAPIError(http.StatusBadRequest, "400006", "Bad Request", "The page number must be a positive integer.")
+	}
+	if opts.maxPageSize > 0 && pageSize > opts.maxPageSize {
+		pageSize = opts.maxPageSize
+	}
+
+	items, pagination := page(items, pageSize, pageNumber)
+	return append([]T(nil), items...), pagination, nil
+}
+
+func userNotFound(userID string) *tableau.APIError {
+	return newAPIError(http.StatusNotFound, "404002", "User not found", fmt.Sprintf("User '%s' could not be found.", userID))
+}
+
+func groupNotFound(groupID string) *tableau.APIError {
+	return newAPIError(http.StatusNotFound, "404011", "Group not found", fmt.Sprintf("Group '%s' could not be found.", groupID))
+}
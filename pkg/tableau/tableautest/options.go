@@ -0,0 +1,89 @@
+package tableautest
+
+const (
+	defaultAPIVersion       = "3.17"
+	defaultTimeToExpiration = "240:00:00"
+	defaultMaxPageSize      = 1000
+	defaultPageWorkers      = 1
+)
+
+type options struct {
+	apiVersion       string
+	timeToExpiration string
+	maxPageSize      int
+	tokenName        string
+	tokenSecret      string
+	username         string
+	password         string
+	noSiteSwitching  bool
+	pageWorkers      int
+}
+
+// Option configures a Server or Memory. Options that only apply to one of them are ignored by the other.
+type Option func(o *options)
+
+func newOptions(opts []Option) options {
+	o := options{
+		apiVersion:       defaultAPIVersion,
+		timeToExpiration: defaultTimeToExpiration,
+		maxPageSize:      defaultMaxPageSize,
+		pageWorkers:      defaultPageWorkers,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithAPIVersion sets the REST API version reported by serverinfo.
+func WithAPIVersion(version string) Option {
+	return func(o *options) {
+		o.apiVersion = version
+	}
+}
+
+// WithMaxPageSize clamps the page size of list endpoints, like Tableau does for sizes above 1000.
+func WithMaxPageSize(maxPageSize int) Option {
+	return func(o *options) {
+		o.maxPageSize = maxPageSize
+	}
+}
+
+// WithTimeToExpiration sets the estimatedTimeToExpiration returned on sign-in, in the hh:mm:ss format.
+func WithTimeToExpiration(value string) Option {
+	return func(o *options) {
+		o.timeToExpiration = value
+	}
+}
+
+// WithPersonalAccessToken rejects sign-ins that don't use the given personal access token.
+// By default any credentials are accepted.
+func WithPersonalAccessToken(name string, secret string) Option {
+	return func(o *options) {
+		o.tokenName = name
+		o.tokenSecret = secret
+	}
+}
+
+// WithUsernamePassword rejects sign-ins that don't use the given username and password.
+// By default any credentials are accepted.
+func WithUsernamePassword(username string, password string) Option {
+	return func(o *options) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithoutSiteSwitching rejects /auth/switchSite, like Tableau Cloud.
+func WithoutSiteSwitching() Option {
+	return func(o *options) {
+		o.noSiteSwitching = true
+	}
+}
+
+// WithPageWorkers sets the number of page workers reported by Memory.
+func WithPageWorkers(workers int) Option {
+	return func(o *options) {
+		o.pageWorkers = workers
+	}
+}
@@ -0,0 +1,505 @@
+// Package tableautest provides an in-process fake of the Tableau REST API for tests.
+package tableautest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-tableau/pkg/tableau"
+)
+
+const (
+	defaultRequestedPageSize   = 100
+	defaultRequestedPageNumber = 1
+)
+
+// Server emulates the parts of the Tableau REST API used by the connector: sign-in, site switching,
+// sites, users, groups and group membership. Requests are served from a Fixture, and membership
+// changes are applied to it. Close must be called once the server is no longer needed.
+type Server struct {
+	*httptest.Server
+
+	mtx        sync.Mutex
+	opts       options
+	sites      []*Site
+	tokens     map[string]string
+	tokenCount int
+	requests   []string
+	throttled  int
+	retryAfter time.Duration
+}
+
+// NewServer starts a server serving the fixture.
+func NewServer(fixture Fixture, opts ...Option) *Server {
+	s := &Server{
+		opts:   newOptions(opts),
+		sites:  fixture.copySites(),
+		tokens: make(map[string]string),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// Throttle answers the next requests to the API with 429 Too Many Requests and the given Retry-After.
+// Sign-in and sign-out are not throttled.
+func (s *Server) Throttle(requests int, retryAfter time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.throttled = requests
+	s.retryAfter = retryAfter
+}
+
+// ExpireSessions invalidates every token, so the next request is rejected with 401 Unauthorized.
+func (s *Server) ExpireSessions() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.tokens = make(map[string]string)
+}
+
+// Sessions returns the number of tokens that are currently signed in.
+func (s *Server) Sessions() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return len(s.tokens)
+}
+
+// Requests returns the requests received so far as "METHOD /path", without the query.
+func (s *Server) Requests() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// CountRequests returns how many requests were received for the method and the path after the API version,
+// e.g. CountRequests(http.MethodGet, "sites/"+siteID+"/users").
+func (s *Server) CountRequests(method string, path string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	count := 0
+	for _, request := range s.requests {
+		reqMethod, reqPath, _ := strings.Cut(request, " ")
+		if reqMethod != method {
+			continue
+		}
+		if _, rest, ok := strings.Cut(strings.TrimPrefix(reqPath, "/api/"), "/"); ok && rest == path {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Members returns the ids of the members of a group.
+func (s *Server) Members(siteID string, groupID string) []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	site := findSite(s.sites, siteID)
+	if site == nil {
+		return nil
+	}
+	group := findGroup(site, groupID)
+	if group == nil {
+		return nil
+	}
+
+	return append([]string(nil), group.Members...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/")
+	if !ok {
+		writeError(w, http.StatusNotFound, "404000", "Resource Not Found", "Unknown path "+r.URL.Path)
+		return
+	}
+	parts := strings.Split(path, "/")[1:]
+
+	switch strings.Join(parts, "/") {
+	case "serverinfo":
+		s.serverInfo(w)
+		return
+	case "auth/signin":
+		s.signIn(w, r)
+		return
+	case "auth/signout":
+		delete(s.tokens, r.Header.Get("X-Tableau-Auth"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if s.throttled > 0 {
+		s.throttled--
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter/time.Second)))
+		writeError(w, http.StatusTooManyRequests, "429000", "Too Many Requests", "The request was throttled.")
+		return
+	}
+
+	token := r.Header.Get("X-Tableau-Auth")
+	siteID, ok := s.tokens[token]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "401002", "Unauthorized Access", "Invalid authentication credentials were provided.")
+		return
+	}
+
+	if len(parts) == 2 && parts[0] == "auth" && parts[1] == "switchSite" {
+		s.switchSite(w, r, token)
+		return
+	}
+
+	if len(parts) == 0 || parts[0] != "sites" {
+		writeError(w, http.StatusNotFound, "404000", "Resource Not Found", "Unknown path "+r.URL.Path)
+		return
+	}
+
+	if len(parts) == 1 {
+		s.listSites(w, r)
+		return
+	}
+
+	site := findSite(s.sites, parts[1])
+	if site == nil {
+		writeError(w, http.StatusNotFound, "404000", "Site not found", fmt.Sprintf("Site '%s' could not be found.", parts[1]))
+		return
+	}
+	if site.ID != siteID {
+		writeError(w, http.StatusForbidden, "403000", "Forbidden", "The session is signed in to another site.")
+		return
+	}
+
+	s.handleSite(w, r, site, parts[2:])
+}
+
+func (s *Server) handleSite(w http.ResponseWriter, r *http.Request, site *Site, parts []string) {
+	route := func(method string, path ...string) bool {
+		if r.Method != method || len(parts) != len(path) {
+			return false
+		}
+		for i := range path {
+			if path[i] != "*" && path[i] != parts[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case route(http.MethodGet):
+		writeJSON(w, http.StatusOK, map[string]interface{}{"site": site.Site})
+	case route(http.MethodGet, "users"):
+		users, pagination, ok := paginate(w, r, site.Users, s.opts.maxPageSize)
+		if ok {
+			writeJSON(w, http.StatusOK, usersResponse(withFields(r, users), pagination))
+		}
+	case route(http.MethodGet, "users", "*"):
+		user := findUser(site, parts[1])
+		if user == nil {
+			writeAPIError(w, userNotFound(parts[1]))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"user": user})
+	case route(http.MethodGet, "users", "*", "groups"):
+		if findUser(site, parts[1]) == nil {
+			writeAPIError(w, userNotFound(parts[1]))
+			return
+		}
+		groups, pagination, ok := paginate(w, r, userGroups(site, parts[1]), s.opts.maxPageSize)
+		if ok {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"pagination": pagination,
+				"groups":     map[string]interface{}{"group": groups},
+			})
+		}
+	case route(http.MethodGet, "groups"):
+		groups := make([]tableau.Group, 0, len(site.Groups))
+		for _, group := range site.Groups {
+			groups = append(groups, group.Group)
+		}
+		page, pagination, ok := paginate(w, r, groups, s.opts.maxPageSize)
+		if ok {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"pagination": pagination,
+				"groups":     map[string]interface{}{"group": page},
+			})
+		}
+	case route(http.MethodGet, "groups", "*", "users"):
+		group := s.groupOrError(w, site, parts[1])
+		if group == nil {
+			return
+		}
+		members := make([]tableau.User, 0, len(group.Members))
+		for _, id := range group.Members {
+			if user := findUser(site, id); user != nil {
+				members = append(members, *user)
+			}
+		}
+		users, pagination, ok := paginate(w, r, members, s.opts.maxPageSize)
+		if ok {
+			writeJSON(w, http.StatusOK, usersResponse(users, pagination))
+		}
+	case route(http.MethodPost, "groups", "*", "users"):
+		group := s.groupOrError(w, site, parts[1])
+		if group == nil {
+			return
+		}
+		s.addMember(w, r, site, group)
+	case route(http.MethodDelete, "groups", "*", "users", "*"):
+		group := s.groupOrError(w, site, parts[1])
+		if group == nil {
+			return
+		}
+		s.removeMember(w, group, parts[3])
+	default:
+		writeError(w, http.StatusNotFound, "404000", "Resource Not Found", "Unknown path "+r.URL.Path)
+	}
+}
+
+func (s *Server) serverInfo(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"serverInfo": tableau.ServerInfo{
+			ProductVersion: tableau.ProductVersion{Value: "2023.1.0", Build: "20231.23.0101.0000"},
+			RestAPIVersion: s.opts.apiVersion,
+		},
+	})
+}
+
+func (s *Server) signIn(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Credentials struct {
+			PersonalAccessTokenName   string `json:"personalAccessTokenName"`
+			PersonalAccessTokenSecret string `json:"personalAccessTokenSecret"`
+			Name                      string `json:"name"`
+			Password                  string `json:"password"`
+			Site                      struct {
+				ContentURL string `json:"contentUrl"`
+			} `json:"site"`
+		} `json:"credentials"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400000", "Bad Request", err.Error())
+		return
+	}
+
+	if s.opts.tokenName != "" && (req.Credentials.PersonalAccessTokenName != s.opts.tokenName ||
+		req.Credentials.PersonalAccessTokenSecret != s.opts.tokenSecret) {
+		writeError(w, http.StatusUnauthorized, "401001", "Signin Error", "Error signing in to Tableau Server")
+		return
+	}
+	if s.opts.username != "" && (req.Credentials.Name != s.opts.username || req.Credentials.Password != s.opts.password) {
+		writeError(w, http.StatusUnauthorized, "401001", "Signin Error", "Error signing in to Tableau Server")
+		return
+	}
+
+	site := s.siteByContentURL(req.Credentials.Site.ContentURL)
+	if site == nil {
+		writeError(w, http.StatusUnauthorized, "401001", "Signin Error", "Error signing in to Tableau Server")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"credentials": s.newCredentials(site)})
+}
+
+func (s *Server) switchSite(w http.ResponseWriter, r *http.Request, token string) {
+	if s.opts.noSiteSwitching {
+		writeError(w, http.StatusForbidden, "403070", "Forbidden", "Switching sites is not supported.")
+		return
+	}
+
+	var req struct {
+		Site struct {
+			ContentURL string `json:"contentUrl"`
+		} `json:"site"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400000", "Bad Request", err.Error())
+		return
+	}
+
+	site := s.siteByContentURL(req.Site.ContentURL)
+	if site == nil {
+		writeError(w, http.StatusUnauthorized, "401000", "Switch Site Error", "The site could not be found.")
+		return
+	}
+
+	delete(s.tokens, token)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"credentials": s.newCredentials(site)})
+}
+
+func (s *Server) newCredentials(site *Site) tableau.Credentials {
+	s.tokenCount++
+	token := fmt.Sprintf("token-%d", s.tokenCount)
+	s.tokens[token] = site.ID
+
+	return tableau.Credentials{
+		Site:                      tableau.Site{ID: site.ID, ContentURL: site.ContentURL},
+		User:                      tableau.User{ID: site.signInUserID()},
+		Token:                     token,
+		EstimatedTimeToExpiration: s.opts.timeToExpiration,
+	}
+}
+
+func (s *Server) listSites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "405000", "Method Not Allowed", r.Method+" is not allowed")
+		return
+	}
+
+	sites := make([]tableau.Site, 0, len(s.sites))
+	for _, site := range s.sites {
+		sites = append(sites, site.Site)
+	}
+
+	page, pagination, ok := paginate(w, r, sites, s.opts.maxPageSize)
+	if ok {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"pagination": pagination,
+			"sites":      map[string]interface{}{"site": page},
+		})
+	}
+}
+
+func (s *Server) addMember(w http.ResponseWriter, r *http.Request, site *Site, group *Group) {
+	var req struct {
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400000", "Bad Request", err.Error())
+		return
+	}
+
+	user := findUser(site, req.User.ID)
+	if user == nil {
+		writeAPIError(w, userNotFound(req.User.ID))
+		return
+	}
+
+	for _, id := range group.Members {
+		if id == user.ID {
+			writeError(w, http.StatusConflict, "409011", "Specified user is already a member of the group",
+				fmt.Sprintf("User '%s' is already a member of group '%s'.", user.ID, group.ID))
+			return
+		}
+	}
+
+	group.Members = append(group.Members, user.ID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"user": user})
+}
+
+func (s *Server) removeMember(w http.ResponseWriter, group *Group, userID string) {
+	for i, id := range group.Members {
+		if id == userID {
+			group.Members = append(group.Members[:i], group.Members[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "404002", "User not found",
+		fmt.Sprintf("User '%s' is not a member of group '%s'.", userID, group.ID))
+}
+
+func (s *Server) groupOrError(w http.ResponseWriter, site *Site, groupID string) *Group {
+	group := findGroup(site, groupID)
+	if group == nil {
+		writeAPIError(w, groupNotFound(groupID))
+	}
+	return group
+}
+
+func (s *Server) siteByContentURL(contentURL string) *Site {
+	for _, site := range s.sites {
+		if strings.EqualFold(site.ContentURL, contentURL) {
+			return site
+		}
+	}
+	return nil
+}
+
+// paginate returns the requested page of items, writing an error envelope for invalid parameters.
+func paginate[T any](w http.ResponseWriter, r *http.Request, items []T, maxPageSize int) ([]T, tableau.Pagination, bool) {
+	pageSize, err := queryInt(r, "pageSize", defaultRequestedPageSize)
+	if err != nil || pageSize < 1 {
+		writeError(w, http.StatusBadRequest, "400007", "Bad Request", "The page size must be a positive integer.")
+		return nil, tableau.Pagination{}, false
+	}
+	pageNumber, err := queryInt(r, "pageNumber", defaultRequestedPageNumber)
+	if err != nil || pageNumber < 1 {
+		writeError(w, http.StatusBadRequest, "400006", "Bad Request", "The page number must be a positive integer.")
+		return nil, tableau.Pagination{}, false
+	}
+
+	if maxPageSize > 0 && pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	items, pagination := page(items, pageSize, pageNumber)
+	return items, pagination, true
+}
+
+func queryInt(r *http.Request, key string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// withFields drops the fields Tableau only returns when fields=_all_ is requested.
+func withFields(r *http.Request, users []tableau.User) []tableau.User {
+	if r.URL.Query().Get("fields") == "_all_" {
+		return users
+	}
+
+	rv := make([]tableau.User, 0, len(users))
+	for _, user := range users {
+		rv = append(rv, tableau.User{
+			Email:    user.Email,
+			ID:       user.ID,
+			FullName: user.FullName,
+			Name:     user.Name,
+			SiteRole: user.SiteRole,
+		})
+	}
+	return rv
+}
+
+func usersResponse(users []tableau.User, pagination tableau.Pagination) map[string]interface{} {
+	return map[string]interface{}{
+		"pagination": pagination,
+		"users":      map[string]interface{}{"user": users},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes a Tableau error envelope.
+func writeError(w http.ResponseWriter, status int, code string, summary string, detail string) {
+	writeAPIError(w, newAPIError(status, code, summary, detail))
+}
+
+func writeAPIError(w http.ResponseWriter, err *tableau.APIError) {
+	writeJSON(w, err.StatusCode, map[string]interface{}{"error": err})
+}
@@ -0,0 +1,191 @@
+package tableau
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// HTTPOptions configures the HTTP client used for sign-in and API requests.
+type HTTPOptions struct {
+	// CABundlePath is a PEM file of certificate authorities trusted in addition to the system pool.
+	CABundlePath string
+	// ClientCertPath and ClientKeyPath are a PEM encoded certificate and key used for mutual TLS.
+	ClientCertPath string
+	ClientKeyPath  string
+	// InsecureSkipVerify disables server certificate verification. Only meant for lab servers.
+	InsecureSkipVerify bool
+	// ProxyURL is the HTTP proxy requests are sent through. When empty the proxy environment variables apply.
+	ProxyURL      string
+	ProxyUsername string
+	ProxyPassword string
+	// NoProxy lists hosts, domains and CIDRs that bypass ProxyURL.
+	NoProxy []string
+	// CaptureDir records every request and response to the directory, see NewCaptureTransport.
+	CaptureDir string
+	// CaptureHashEmails replaces email addresses in the capture by a hash.
+	CaptureHashEmails bool
+	// ReplayDir answers requests from a capture instead of sending them, see NewReplayTransport.
+	ReplayDir string
+}
+
+// NewHTTPClient returns an HTTP client configured with the given options.
+func NewHTTPClient(ctx context.Context, opts HTTPOptions) (*http.Client, error) {
+	if opts.ReplayDir != "" {
+		transport, err := NewReplayTransport(opts.ReplayDir)
+		if err != nil {
+			return nil, err
+		}
+
+		return &http.Client{
+			Transport: &loggingTransport{next: transport, logger: ctxzap.Extract(ctx)},
+		}, nil
+	}
+
+	httpClient, err := newNetworkHTTPClient(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CaptureDir != "" {
+		httpClient.Transport, err = NewCaptureTransport(httpClient.Transport, opts.CaptureDir, opts.CaptureHashEmails)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return httpClient, nil
+}
+
+func newNetworkHTTPClient(ctx context.Context, opts HTTPOptions) (*http.Client, error) {
+	tlsConfig, err := NewTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ProxyURL == "" {
+		return uhttp.NewClient(ctx,
+			uhttp.WithLogger(true, ctxzap.Extract(ctx)),
+			uhttp.WithTLSClientConfig(tlsConfig),
+		)
+	}
+
+	// uhttp always uses the proxy environment variables, so the transport is built here instead.
+	proxy, err := NewProxyFunc(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("tableau-connector: unexpected default transport %T", http.DefaultTransport)
+	}
+	base := transport.Clone()
+	base.Proxy = proxy
+	base.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: &loggingTransport{next: base, logger: ctxzap.Extract(ctx)},
+	}, nil
+}
+
+// NewProxyFunc returns the proxy selection function for the given options.
+func NewProxyFunc(opts HTTPOptions) (func(*http.Request) (*url.URL, error), error) {
+	proxyUrl, err := url.Parse(opts.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("tableau-connector: invalid proxy url: %w", err)
+	}
+	if proxyUrl.Scheme == "" || proxyUrl.Host == "" {
+		return nil, fmt.Errorf("tableau-connector: proxy url %q must include a scheme and host", opts.ProxyURL)
+	}
+
+	if opts.ProxyUsername != "" {
+		proxyUrl.User = url.UserPassword(opts.ProxyUsername, opts.ProxyPassword)
+	}
+
+	proxyConfig := &httpproxy.Config{
+		HTTPProxy:  proxyUrl.String(),
+		HTTPSProxy: proxyUrl.String(),
+		NoProxy:    strings.Join(opts.NoProxy, ","),
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
+
+// loggingTransport logs requests the same way the uhttp transport does.
+type loggingTransport struct {
+	next   http.RoundTripper
+	logger *zap.Logger
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fields := []zap.Field{
+		zap.String("http.method", req.Method),
+		zap.String("http.url_details.host", req.URL.Host),
+		zap.String("http.url_details.path", req.URL.Path),
+	}
+	t.logger.Debug("Request started", fields...)
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	if resp != nil {
+		fields = append(fields, zap.Int("http.status_code", resp.StatusCode))
+	}
+	t.logger.Debug("Request complete", fields...)
+
+	return resp, err
+}
+
+// NewTLSConfig builds the TLS client configuration for the given options.
+func NewTLSConfig(opts HTTPOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.InsecureSkipVerify, //nolint:gosec // opt-in for lab servers with self-signed certificates.
+	}
+
+	if opts.CABundlePath != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		bundle, err := os.ReadFile(opts.CABundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("tableau-connector: failed to read ca bundle: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("tableau-connector: ca bundle %s contains no certificates", opts.CABundlePath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.ClientCertPath != "" || opts.ClientKeyPath != "" {
+		if opts.ClientCertPath == "" || opts.ClientKeyPath == "" {
+			return nil, fmt.Errorf("tableau-connector: client certificate and key must be provided together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertPath, opts.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("tableau-connector: failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}